@@ -0,0 +1,302 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ridwanulhoquejr/todo-app/internal/data"
+	"github.com/ridwanulhoquejr/todo-app/internal/validator"
+)
+
+// accountDeletionGracePeriod is how long a user has to change their mind after asking
+// for their account to be deleted.
+const accountDeletionGracePeriod = 14 * 24 * time.Hour
+
+// exportTTL is how long a generated export can be downloaded.
+const exportTTL = 7 * 24 * time.Hour
+
+// deleteCurrentUserHandler schedules the deletion of the user's account. Once the
+// grace period is over, the account is deleted along with everything belonging to
+// it: todos, tokens, API keys and the rest.
+func (app *application) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var payload struct {
+		CurrentPassword string `json:"current_password"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(payload.CurrentPassword != "", "current_password", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	match, err := user.Password.Matches(payload.CurrentPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	deletion := &data.AccountDeletion{
+		UserID:       user.ID,
+		ScheduledFor: time.Now().Add(accountDeletionGracePeriod),
+	}
+
+	err = app.models.Deletion.Schedule(deletion)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"deletion": deletion}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) cancelAccountDeletionHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	err := app.models.Deletion.Cancel(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "account deletion cancelled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// deleteDueAccounts deletes the accounts whose grace period is over. It runs as a
+// periodic job.
+func (app *application) deleteDueAccounts() error {
+
+	ids, err := app.models.Deletion.GetDue()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		// signed access tokens would keep working after the account is gone
+		err := app.revokeAccessTokensForUser(id)
+		if err != nil {
+			return err
+		}
+
+		err = app.models.User.Delete(id)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			return err
+		}
+
+		app.logger.PrintInfo("account deleted", map[string]string{"user_id": strconv.FormatInt(id, 10)})
+	}
+
+	return nil
+}
+
+// requestExportHandler asks for an export of the user's data. The archive is put
+// together in the background; while one is on its way, asking again returns it
+// rather than starting another.
+func (app *application) requestExportHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	export, err := app.models.Export.GetLatestForUser(user.ID, false)
+	switch {
+	case err == nil && (export.Status == data.ExportPending || export.Status == data.ExportProcessing):
+		// reuse the export in progress
+	case err == nil || errors.Is(err, data.ErrRecordNotFound):
+		export = &data.Export{UserID: user.ID}
+
+		err = app.models.Export.Insert(export)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	default:
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"export": export}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// downloadExportHandler sends the archive of the latest export once it's ready, and
+// its status until then.
+func (app *application) downloadExportHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	export, err := app.models.Export.GetLatestForUser(user.ID, true)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if export.Status != data.ExportReady {
+		status := http.StatusAccepted
+		if export.Status == data.ExportFailed {
+			status = http.StatusOK
+		}
+
+		err = app.writeJSON(w, status, envelope{"export": export}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	filename := fmt.Sprintf("todo-export-%s.zip", export.CreationTime.Format("2006-01-02"))
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Content-Length", strconv.Itoa(len(export.Archive)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(export.Archive)
+}
+
+// processExports generates the pending exports, one after the other. It runs as a
+// periodic job.
+func (app *application) processExports() error {
+
+	for {
+		select {
+		case <-app.done:
+			return nil
+		default:
+		}
+
+		export, err := app.models.Export.Claim()
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		archive, err := app.buildExport(export.UserID)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"export_id": strconv.FormatInt(export.ID, 10)})
+
+			err = app.models.Export.Fail(export.ID, "the export could not be generated")
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = app.models.Export.Complete(export.ID, archive, exportTTL)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// buildExport puts together a zip archive of the user's data, one JSON file per kind
+// of record.
+func (app *application) buildExport(userID int64) ([]byte, error) {
+
+	user, err := app.models.User.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := app.models.Permission.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	todos, err := app.models.Export.TodosJSON(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := app.models.Token.GetSessionsForUser(userID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	apiKeys, err := app.models.Token.GetPersonalAccessForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", envelope{"user": user, "permissions": permissions}},
+		{"todos.json", todos},
+		{"sessions.json", sessions},
+		{"api_keys.json", apiKeys},
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	for _, f := range files {
+		js, err := json.MarshalIndent(f.data, "", "\t")
+		if err != nil {
+			return nil, err
+		}
+
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = fw.Write(js)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
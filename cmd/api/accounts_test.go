@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/ridwanulhoquejr/todo-app/internal/data"
+)
+
+// TestDeleteDueAccounts checks that the access tokens of an account are revoked
+// before the account is deleted.
+func TestDeleteDueAccounts(t *testing.T) {
+
+	app := newJWTApplication(t)
+
+	user := &data.User{ID: 42, Name: "Alice", Email: "alice@example.com", Activated: true}
+
+	db, sqlDB := newFakeDB(map[int64]string{user.ID: user.Email})
+	defer sqlDB.Close()
+	db.due = []int64{user.ID}
+	app.models = data.NewModels(sqlDB)
+
+	token, err := app.signAccessToken(user, nil, "family")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.deleteDueAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"SELECT user_id FROM",
+		"INSERT INTO token_denylist",
+		"BEGIN",
+		"DELETE FROM todo",
+		"DELETE FROM users",
+		"COMMIT",
+	}
+	if got := db.log(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got statements\n%q\nwant\n%q", got, want)
+	}
+
+	if status := authenticateStatus(app, token.Plaintext); status != http.StatusUnauthorized {
+		t.Errorf("access token of the deleted user got status %d, want %d", status, http.StatusUnauthorized)
+	}
+}
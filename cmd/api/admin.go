@@ -0,0 +1,383 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ridwanulhoquejr/todo-app/internal/data"
+	"github.com/ridwanulhoquejr/todo-app/internal/validator"
+)
+
+// impersonationTokenTTL is kept short, an admin should only act as another user for
+// as long as it takes to look into a problem.
+const impersonationTokenTTL = time.Hour
+
+// readUserParam loads the user named by the {id} path parameter. If that fails, the
+// error response has already been sent and false is returned.
+func (app *application) readUserParam(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil, false
+	}
+
+	if id <= 0 {
+		app.badRequestResponse(w, r, errInvalidPathParam)
+		return nil, false
+	}
+
+	user, err := app.models.User.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// audit records an action taken by the current user against another user.
+func (app *application) audit(r *http.Request, action string, targetUserID int64, details map[string]string) error {
+
+	actor := app.contextGetUser(r)
+
+	return app.models.Audit.Insert(&data.AuditEvent{
+		ActorID:      actor.ID,
+		Action:       action,
+		TargetUserID: targetUserID,
+		Details:      details,
+		IPAddress:    clientIP(r),
+	})
+}
+
+func (app *application) adminListUsersHandler(w http.ResponseWriter, r *http.Request) {
+
+	qs := r.URL.Query()
+	v := validator.New()
+
+	var queries data.Queries
+	queries.Pagination.Page = app.readInt(qs, "page", 1, v)
+	queries.Pagination.PageSize = app.readInt(qs, "page_size", 20, v)
+	queries.Sorts.Sort = app.readString(qs, "sort", "id")
+	queries.Sorts.SafeList = []string{"id", "name", "email", "creation_time", "-id", "-name", "-email", "-creation_time"}
+
+	search := app.readString(qs, "search", "")
+
+	if data.ValidateQueries(v, queries); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.User.GetAll(search, queries)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": users, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) adminGetUserHandler(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	permissions, err := app.models.Permission.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// adminUpdateActivationHandler activates or deactivates an account. Deactivated users
+// keep their tokens, but requireActivatedUser turns them away from the todo routes.
+func (app *application) adminUpdateActivationHandler(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		Activated *bool `json:"activated"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(payload.Activated != nil, "activated", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user.Activated = *payload.Activated
+
+	err = app.models.User.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// signed access tokens carry the activation state, so force them to be reissued
+	err = app.revokeAccessTokensForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	action := data.AuditUserActivated
+	if !user.Activated {
+		action = data.AuditUserDeactivated
+	}
+
+	err = app.audit(r, action, user.ID, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, user, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) adminDeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+
+	admin := app.contextGetUser(r)
+
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	// an admin locking themselves out by accident is never what they meant to do
+	if user.ID == admin.ID {
+		app.badRequestResponse(w, r, errors.New("you cannot delete your own account"))
+		return
+	}
+
+	// the access tokens are revoked first, so that none of them outlives the account
+	err := app.revokeAccessTokensForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.User.Delete(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.audit(r, data.AuditUserDeleted, user.ID, map[string]string{"email": user.Email})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// adminLogoutUserHandler revokes every session, API key and impersonation token of
+// the user.
+func (app *application) adminLogoutUserHandler(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	scopes := []string{
+		data.ScopeAuthentication,
+		data.ScopeRefresh,
+		data.ScopePersonalAccess,
+		data.ScopeImpersonation,
+		data.ScopeOAuthAccess,
+		data.ScopeOAuthRefresh,
+	}
+
+	for _, scope := range scopes {
+		err := app.models.Token.DeleteAllForUser(scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err := app.revokeAccessTokensForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.audit(r, data.AuditUserLoggedOut, user.ID, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "all tokens of the user successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// adminImpersonateHandler issues a short-lived token acting as the user. The token is
+// limited to the todo scopes, so it can't be used to manage the user's credentials,
+// and every request made with it is written to the audit log.
+func (app *application) adminImpersonateHandler(w http.ResponseWriter, r *http.Request) {
+
+	admin := app.contextGetUser(r)
+
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	if user.ID == admin.ID {
+		app.badRequestResponse(w, r, errors.New("you cannot impersonate yourself"))
+		return
+	}
+
+	token, err := app.models.Token.NewImpersonation(user.ID, admin.ID, impersonationTokenTTL, data.APIScopes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.audit(r, data.AuditImpersonationStart, user.ID, map[string]string{
+		"token_id": strconv.FormatInt(token.ID, 10),
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"user": user, "impersonation_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// adminGrantRoleHandler grants all the permissions of a role to a user. Signed access
+// tokens carry the permissions, so in JWT mode they take effect at the next refresh.
+func (app *application) adminGrantRoleHandler(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		Role string `json:"role"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(validator.In(payload.Role, data.Roles...), "role", "must be a known role"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Permission.AddRoleForUser(user.ID, payload.Role)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.audit(r, data.AuditUserRoleGranted, user.ID, map[string]string{"role": payload.Role})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.models.Permission.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// adminListAuditHandler lists the audit log, optionally only for one user.
+func (app *application) adminListAuditHandler(w http.ResponseWriter, r *http.Request) {
+
+	qs := r.URL.Query()
+	v := validator.New()
+
+	var pagination data.Pagination
+	pagination.Page = app.readInt(qs, "page", 1, v)
+	pagination.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	userID := app.readInt(qs, "user_id", 0, v)
+
+	if data.ValidatePagination(v, pagination); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	events, metadata, err := app.models.Audit.GetAll(int64(userID), pagination)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"events": events, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
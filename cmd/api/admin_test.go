@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ridwanulhoquejr/todo-app/internal/data"
+)
+
+// TestAdminDeleteUser checks that deleting a user revokes their access tokens before
+// the account goes, and that the deletion is audited.
+func TestAdminDeleteUser(t *testing.T) {
+
+	app := newJWTApplication(t)
+
+	admin := &data.User{ID: 1, Name: "Admin", Email: "admin@example.com", Activated: true}
+	user := &data.User{ID: 42, Name: "Alice", Email: "alice@example.com", Activated: true}
+
+	db, sqlDB := newFakeDB(map[int64]string{admin.ID: admin.Email, user.ID: user.Email})
+	defer sqlDB.Close()
+	app.models = data.NewModels(sqlDB)
+
+	token, err := app.signAccessToken(user, nil, "family")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "42")
+
+	r := httptest.NewRequest(http.MethodDelete, "/v1/admin/users/42", nil)
+	r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+	r = app.contextSetUser(r, admin)
+
+	w := httptest.NewRecorder()
+	app.adminDeleteUserHandler(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	want := []string{
+		"SELECT id, name,",
+		"INSERT INTO token_denylist",
+		"BEGIN",
+		"DELETE FROM todo",
+		"DELETE FROM users",
+		"COMMIT",
+		"INSERT INTO audit_log",
+	}
+	if got := db.log(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got statements\n%q\nwant\n%q", got, want)
+	}
+
+	if status := authenticateStatus(app, token.Plaintext); status != http.StatusUnauthorized {
+		t.Errorf("access token of the deleted user got status %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+// TestAdminDeleteUserNotFound checks that nothing is revoked or audited for a user
+// who doesn't exist.
+func TestAdminDeleteUserNotFound(t *testing.T) {
+
+	app := newJWTApplication(t)
+
+	admin := &data.User{ID: 1, Name: "Admin", Email: "admin@example.com", Activated: true}
+
+	db, sqlDB := newFakeDB(map[int64]string{admin.ID: admin.Email})
+	defer sqlDB.Close()
+	app.models = data.NewModels(sqlDB)
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "42")
+
+	r := httptest.NewRequest(http.MethodDelete, "/v1/admin/users/42", nil)
+	r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+	r = app.contextSetUser(r, admin)
+
+	w := httptest.NewRecorder()
+	app.adminDeleteUserHandler(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusNotFound, w.Body)
+	}
+
+	if got, want := db.log(), []string{"SELECT id, name,"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got statements %q, want %q", got, want)
+	}
+}
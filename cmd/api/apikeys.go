@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ridwanulhoquejr/todo-app/internal/data"
+	"github.com/ridwanulhoquejr/todo-app/internal/validator"
+)
+
+func (app *application) createPersonalAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	var payload struct {
+		Name          string   `json:"name"`
+		ExpiresInDays int      `json:"expires_in_days"`
+		Scopes        []string `json:"scopes"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidatePersonalAccessToken(v, payload.Name, payload.ExpiresInDays, payload.Scopes); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ttl := time.Duration(payload.ExpiresInDays) * 24 * time.Hour
+
+	token, err := app.models.Token.NewPersonalAccess(user.ID, payload.Name, ttl, payload.Scopes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// this is the only time the plaintext token is ever shown
+	err = app.writeJSON(w, http.StatusCreated, token, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) listPersonalAccessTokensHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	tokens, err := app.models.Token.GetPersonalAccessForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"api_keys": tokens}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) deletePersonalAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if id <= 0 {
+		app.badRequestResponse(w, r, errInvalidPathParam)
+		return
+	}
+
+	err = app.models.Token.DeletePersonalAccess(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "api key successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+)
+
+// archiveTodoHandler puts a todo and its subtasks in the archive, which hides them
+// from the todo listings unless archived=true is asked for.
+func (app *application) archiveTodoHandler(w http.ResponseWriter, r *http.Request) {
+	app.setTodoArchived(w, r, true)
+}
+
+// unarchiveTodoHandler takes a todo and its subtasks back out of the archive.
+func (app *application) unarchiveTodoHandler(w http.ResponseWriter, r *http.Request) {
+	app.setTodoArchived(w, r, false)
+}
+
+func (app *application) setTodoArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+
+	user := app.contextGetUser(r)
+
+	todo, ok := app.readTodoParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Todo.SetArchived(todo.ID, user.ID, archived)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	todo, err = app.models.Todo.Get(todo.ID, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, todo, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
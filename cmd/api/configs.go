@@ -1,21 +1,72 @@
 package main
 
 import (
+	"encoding/base64"
+	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/ridwanulhoquejr/todo-app/internal/jwt"
+	"github.com/ridwanulhoquejr/todo-app/internal/oidc"
 )
 
 const version = "1.0.0"
 
 var environments = [2]string{"dev", "production"}
 
+// Supported formats for access tokens. Opaque tokens are looked up in the token
+// table on every request, JWTs are verified using their signature alone.
+const (
+	tokenFormatOpaque = "opaque"
+	tokenFormatJWT    = "jwt"
+)
+
 // config for our application
 type config struct {
 	port int
 	env  string
+
+	// mailer selects the mail backend, either "smtp" or "file"
+	mailer string
+	smtp   struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+	}
+	// mailDir is where the file mailer drops its messages
+	mailDir string
+
+	token struct {
+		format string
+		jwt    struct {
+			issuer string
+			keys   *jwt.KeySet
+		}
+	}
+
+	totp struct {
+		// issuer is the name authenticator apps show next to the codes
+		issuer string
+		// key encrypts the TOTP secrets stored in the database. Two-factor
+		// authentication can't be enrolled in when it isn't set.
+		key []byte
+	}
+
+	// oidc lists the identity providers users can log in with
+	oidc []oidc.Config
+
+	// trashRetention is how long deleted todos stay in the trash before they're
+	// purged for good
+	trashRetention time.Duration
+	// autoArchiveAfter is how long completed todos stay around before they're
+	// archived, zero turns auto-archiving off
+	autoArchiveAfter time.Duration
 }
 
 func Configs() *config {
@@ -37,8 +88,134 @@ func Configs() *config {
 		log.Fatal("cannot convert API port string to int")
 	}
 
-	return &config{
+	cfg := &config{
 		port: port,
 		env:  environments[0],
 	}
+
+	cfg.mailer = getEnv("MAILER", "file")
+	cfg.mailDir = getEnv("MAIL_DIR", "tmp/mail")
+	cfg.smtp.host = getEnv("SMTP_HOST", "localhost")
+	cfg.smtp.port = getEnvInt("SMTP_PORT", 25)
+	cfg.smtp.username = getEnv("SMTP_USERNAME", "")
+	cfg.smtp.password = getEnv("SMTP_PASSWORD", "")
+	cfg.smtp.sender = getEnv("SMTP_SENDER", "Todo <no-reply@todo.local>")
+
+	cfg.token.format = getEnv("TOKEN_FORMAT", tokenFormatOpaque)
+	if cfg.token.format == tokenFormatJWT {
+		keys, err := parseJWTKeys(os.Getenv("JWT_KEYS"), os.Getenv("JWT_SIGNING_KEY"))
+		if err != nil {
+			log.Fatalf("invalid JWT_KEYS: %s", err)
+		}
+		cfg.token.jwt.keys = keys
+		cfg.token.jwt.issuer = getEnv("JWT_ISSUER", "todo-app")
+	}
+
+	cfg.totp.issuer = getEnv("TOTP_ISSUER", "Todo")
+	if key := os.Getenv("TOTP_ENCRYPTION_KEY"); key != "" {
+		cfg.totp.key, err = base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			log.Fatalf("invalid TOTP_ENCRYPTION_KEY: %s", err)
+		}
+	}
+
+	cfg.trashRetention = time.Duration(getEnvInt("TRASH_RETENTION_DAYS", 30)) * 24 * time.Hour
+	cfg.autoArchiveAfter = time.Duration(getEnvInt("AUTO_ARCHIVE_DAYS", 30)) * 24 * time.Hour
+
+	cfg.oidc, err = parseOIDCProviders(os.Getenv("OIDC_PROVIDERS"))
+	if err != nil {
+		log.Fatalf("invalid OIDC_PROVIDERS: %s", err)
+	}
+
+	return cfg
+}
+
+// parseOIDCProviders reads the configuration of every provider in the comma separated
+// list of names. The settings of a provider come from the OIDC_<NAME>_* variables,
+// e.g. OIDC_COMPANY_ISSUER for the provider named "company".
+func parseOIDCProviders(list string) ([]oidc.Config, error) {
+
+	var providers []oidc.Config
+
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+
+		p := oidc.Config{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		}
+
+		if p.Issuer == "" || p.ClientID == "" || p.RedirectURL == "" {
+			return nil, fmt.Errorf("provider %q: %sISSUER, %sCLIENT_ID and %sREDIRECT_URL must be set", name, prefix, prefix, prefix)
+		}
+
+		providers = append(providers, p)
+	}
+
+	return providers, nil
+}
+
+// parseJWTKeys parses a comma separated list of "kid:algorithm:base64-key" entries.
+// New tokens are signed with the key named by signingKeyID, or the first key in the
+// list if it is empty; all the others are only used to verify older tokens.
+func parseJWTKeys(list, signingKeyID string) (*jwt.KeySet, error) {
+
+	var keys []*jwt.Key
+
+	for _, entry := range strings.Split(list, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("malformed key entry %q", entry)
+		}
+
+		material, err := base64.StdEncoding.DecodeString(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", parts[0], err)
+		}
+
+		key, err := jwt.NewKey(parts[0], parts[1], material)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if signingKeyID == "" {
+		signingKeyID = keys[0].ID
+	}
+
+	return jwt.NewKeySet(signingKeyID, keys...)
+}
+
+// getEnv returns the value of the environment variable key, or defaultValue
+// if it is not set
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvInt is like getEnv but converts the value to an int
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("cannot convert %s to int", key)
+	}
+	return i
 }
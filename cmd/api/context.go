@@ -15,6 +15,15 @@ type contextKey string
 // define a unique key for for our User context
 const userContextKey = contextKey("user")
 
+// define a unique key for the plaintext token the request was authenticated with
+const tokenContextKey = contextKey("token")
+
+// define a unique key for the API scopes of a restricted token
+const scopesContextKey = contextKey("scopes")
+
+// define a unique key for the permissions carried by a signed access token
+const permissionsContextKey = contextKey("permissions")
+
 // func to set User context
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 
@@ -34,3 +43,52 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// func to set the plaintext authentication token in the context
+func (app *application) contextSetToken(r *http.Request, token string) *http.Request {
+
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// contextGetToken retrieves the plaintext token set by the authenticate middleware.
+// Like contextGetUser, it should only be called for routes behind authenticate.
+func (app *application) contextGetToken(r *http.Request) string {
+
+	token, ok := r.Context().Value(tokenContextKey).(string)
+	if !ok {
+		panic("missing token value in request context")
+	}
+	return token
+}
+
+// func to set the API scopes the request is restricted to
+func (app *application) contextSetScopes(r *http.Request, scopes []string) *http.Request {
+
+	ctx := context.WithValue(r.Context(), scopesContextKey, scopes)
+	return r.WithContext(ctx)
+}
+
+// contextGetScopes returns the API scopes of the token used for the request, and
+// whether the request is restricted to them at all. Session tokens never are.
+func (app *application) contextGetScopes(r *http.Request) ([]string, bool) {
+
+	scopes, ok := r.Context().Value(scopesContextKey).([]string)
+	return scopes, ok
+}
+
+// func to set the permissions of the user, when the token the request was made with
+// already carries them
+func (app *application) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+// contextGetPermissions returns the permissions set by authenticate, and whether there
+// were any to set. Only signed access tokens carry them.
+func (app *application) contextGetPermissions(r *http.Request) (data.Permissions, bool) {
+
+	permissions, ok := r.Context().Value(permissionsContextKey).(data.Permissions)
+	return permissions, ok
+}
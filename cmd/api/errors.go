@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func (app *application) URLNotFound(w http.ResponseWriter, r *http.Request) {
@@ -53,12 +56,45 @@ func (app *application) missingAuthenticationTokenResponse(w http.ResponseWriter
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+// the token is valid, but wasn't granted the scope needed for this route
+func (app *application) insufficientScopeResponse(w http.ResponseWriter, r *http.Request, scope string) {
+
+	// RFC 6750 section 3.1: tell the client which scope it was missing
+	if scope != "" {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, scope))
+	} else {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+	}
+
+	message := "your token doesn't have the necessary scope to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 // invalid credentials
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+// wrong, or already used, TOTP or recovery code
+func (app *application) invalidTOTPCodeResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid two-factor authentication code"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) totpNotConfiguredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "two-factor authentication is not available on this server"
+	app.errorResponse(w, r, http.StatusNotImplemented, message)
+}
+
+// the account or client IP address is locked out after too many failed logins
+func (app *application) tooManyLoginAttemptsResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	message := "too many failed login attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 	message := "unable to update the record due to an edit conflict, please try again"
 	app.errorResponse(w, r, http.StatusConflict, message)
@@ -94,10 +130,10 @@ func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.
 }
 
 // not permitted
-// func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
-// 	message := "your user account doesn't have the necessary permissions to access this resource"
-// 	app.errorResponse(w, r, http.StatusForbidden, message)
-// }
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
 
 // // not authorized
 // func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
@@ -105,8 +141,8 @@ func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.
 // 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 // }
 
-// // not activated
-// func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
-// 	message := "your user account must be activated to access this resource"
-// 	app.errorResponse(w, r, http.StatusForbidden, message)
-// }
+// not activated
+func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account must be activated to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeDB stands in for Postgres in handler tests. It knows just enough of the
+// queries about users, the denylist and the audit log to run the account deletion
+// paths, and records every statement so that tests can check their order.
+type fakeDB struct {
+	mu         sync.Mutex
+	users      map[int64]string // id to email
+	due        []int64          // users whose account deletion is due
+	statements []string
+}
+
+func newFakeDB(users map[int64]string) (*fakeDB, *sql.DB) {
+	db := &fakeDB{users: users}
+	return db, sql.OpenDB(db)
+}
+
+// log returns the statements run so far, each cut down to its first three words.
+func (db *fakeDB) log() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return append([]string(nil), db.statements...)
+}
+
+func (db *fakeDB) record(query string) string {
+
+	fields := strings.Fields(query)
+	if len(fields) > 3 {
+		fields = fields[:3]
+	}
+	statement := strings.Join(fields, " ")
+
+	db.statements = append(db.statements, statement)
+	return strings.Join(strings.Fields(query), " ")
+}
+
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: db}, nil }
+func (db *fakeDB) Open(string) (driver.Conn, error)             { return &fakeConn{db: db}, nil }
+func (db *fakeDB) Driver() driver.Driver                        { return db }
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("fakedb: prepared statements are not supported")
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	c.db.record("BEGIN")
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	c.db.record("COMMIT")
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	c.db.record("ROLLBACK")
+	return nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	query = c.db.record(query)
+
+	switch {
+	case strings.HasPrefix(query, "INSERT INTO token_denylist"):
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(query, "DELETE FROM todo WHERE user_id"):
+		return driver.RowsAffected(0), nil
+	case strings.HasPrefix(query, "DELETE FROM users WHERE id"):
+		id := args[0].Value.(int64)
+		if _, ok := c.db.users[id]; !ok {
+			return driver.RowsAffected(0), nil
+		}
+		delete(c.db.users, id)
+		return driver.RowsAffected(1), nil
+	}
+	return nil, fmt.Errorf("fakedb: unexpected statement %q", query)
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	query = c.db.record(query)
+
+	switch {
+	case strings.HasPrefix(query, "SELECT id, name, email, password_hash"):
+		id := args[0].Value.(int64)
+		email, ok := c.db.users[id]
+		if !ok {
+			return &fakeRows{}, nil
+		}
+		return &fakeRows{values: [][]driver.Value{
+			{id, "User", email, []byte("hash"), true, "UTC", int64(1), time.Now()},
+		}}, nil
+	case strings.HasPrefix(query, "SELECT user_id FROM account_deletions"):
+		rows := &fakeRows{}
+		for _, id := range c.db.due {
+			rows.values = append(rows.values, []driver.Value{id})
+		}
+		return rows, nil
+	case strings.HasPrefix(query, "INSERT INTO audit_log"):
+		return &fakeRows{values: [][]driver.Value{{int64(1), time.Now()}}}, nil
+	}
+	return nil, fmt.Errorf("fakedb: unexpected query %q", query)
+}
+
+type fakeRows struct {
+	values [][]driver.Value
+}
+
+// Columns only has to be as long as the rows, as the names are never looked at.
+func (r *fakeRows) Columns() []string {
+	if len(r.values) == 0 {
+		return make([]string, 8)
+	}
+	return make([]string, len(r.values[0]))
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
@@ -5,9 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -26,6 +28,25 @@ type APIResponse struct {
 	Data    any    `json:"data,omitempty"`
 }
 
+// nullable is a JSON field of a partial update which tells apart a field that was left
+// out of the payload (Set is false) from one that was explicitly null (Set is true,
+// Valid is false), which a plain pointer can't.
+type nullable[T any] struct {
+	Set   bool
+	Valid bool
+	Value T
+}
+
+func (n *nullable[T]) UnmarshalJSON(b []byte) error {
+	n.Set = true
+	if string(b) == "null" {
+		n.Valid = false
+		return nil
+	}
+	n.Valid = true
+	return json.Unmarshal(b, &n.Value)
+}
+
 // readJSON: convert json data to go-struct data
 func (app *application) readJSON(
 	w http.ResponseWriter, r *http.Request, dst any) error {
@@ -173,7 +194,8 @@ func (app *application) readInt(
 }
 
 // read time.Time
-func (app *application) readTime(qs url.Values, key string, defaultValue time.Time) time.Time {
+func (app *application) readTime(
+	qs url.Values, key string, defaultValue time.Time, v *validator.Validator) time.Time {
 
 	t := qs.Get(key)
 
@@ -182,23 +204,34 @@ func (app *application) readTime(qs url.Values, key string, defaultValue time.Ti
 		return defaultValue
 	}
 
-	// Parse the time string into a time.Time object
+	// Parse the date string into a time.Time object, if that fails we record an error
+	// message in the validator instance
 	parsedTime, err := time.Parse("2006-01-02", t)
 	if err != nil {
-		app.logger.PrintInfo("error while parsing datetime sort", nil)
+		v.AddError(key, "must be a date in the YYYY-MM-DD format")
 		return defaultValue
 	}
 
 	return parsedTime
 }
 
-// read boolean
-// TODO: need to complete this for completed field
-// func (app *application) readBoolean(
-// 	qs url.Values, key, defaultValue string, v *validator.Validator) string {
+// The readBoolean() helper reads a boolean value ("true", "false", "1", "0"...) from the
+// query string. If no matching key could be found it returns the provided default value.
+func (app *application) readBoolean(
+	qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+
+	value := qs.Get(key)
+	if value == "" || value == "null" {
+		return defaultValue
+	}
 
-// 	return ""
-// }
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		v.AddError(key, "must be true or false")
+		return defaultValue
+	}
+	return b
+}
 
 // read string
 func (app *application) readString(
@@ -212,14 +245,24 @@ func (app *application) readString(
 }
 
 // read CSV
-// func (app *application) readCSV(qs url.Values, key string, defaultValue []string) []string {
+func (app *application) readCSV(qs url.Values, key string, defaultValue []string) []string {
+
+	csv := qs.Get(key)
+	if csv == "" {
+		return defaultValue
+	}
+	return strings.Split(csv, ",")
+}
+
+// clientIP returns the IP address of the client, without the port
+func clientIP(r *http.Request) string {
 
-// 	csv := qs.Get(key)
-// 	if csv == "" {
-// 		return defaultValue
-// 	}
-// 	return strings.Split(csv, ",")
-// }
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
 
 func (app *application) readIDParam(r *http.Request) (int64, error) {
 
@@ -232,3 +275,24 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 
 	return id, nil
 }
+
+// The background() helper accepts an arbitrary function as a parameter and runs it in
+// a background go-routine. Any panic is recovered and logged rather than taking the
+// whole application down, and the go-routine is tracked by app.wg so that serve()
+// can wait for it during a graceful shutdown.
+func (app *application) background(fn func()) {
+
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+			}
+		}()
+
+		fn()
+	}()
+}
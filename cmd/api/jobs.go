@@ -0,0 +1,67 @@
+package main
+
+import (
+	"time"
+)
+
+// startBackgroundJobs starts the periodic jobs of the application. They all stop once
+// app.done is closed during shutdown.
+func (app *application) startBackgroundJobs() {
+
+	app.periodic("purge-login-failures", time.Hour, func() error {
+		return app.models.Login.DeleteExpired(loginFailureWindow)
+	})
+
+	app.periodic("purge-oauth-codes", time.Hour, app.models.OAuthCode.DeleteExpired)
+	app.periodic("delete-accounts", time.Hour, app.deleteDueAccounts)
+	app.periodic("process-exports", 10*time.Second, app.processExports)
+	app.periodic("purge-exports", time.Hour, app.models.Export.DeleteExpired)
+	app.periodic("send-reminders", 15*time.Second, app.sendDueReminders)
+
+	app.periodic("purge-trash", time.Hour, func() error {
+		return app.models.Todo.PurgeTrash(app.config.trashRetention)
+	})
+
+	if app.config.autoArchiveAfter > 0 {
+		app.periodic("archive-completed-todos", time.Hour, func() error {
+			return app.models.Todo.ArchiveCompleted(app.config.autoArchiveAfter)
+		})
+	}
+
+	if len(app.oidc) > 0 {
+		app.periodic("purge-oidc-login-states", time.Hour, app.models.LoginState.DeleteExpired)
+	}
+
+	if app.config.token.format == tokenFormatJWT {
+		// load the denylist once up front, so that we don't accept revoked tokens
+		// until the first tick
+		err := app.refreshDenylist()
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"job": "refresh-denylist"})
+		}
+		app.periodic("refresh-denylist", 30*time.Second, app.refreshDenylist)
+	}
+}
+
+// periodic runs fn every interval in a background go-routine, until the application
+// shuts down. Errors are logged and the job carries on with the next tick.
+func (app *application) periodic(name string, interval time.Duration, fn func() error) {
+
+	app.background(func() {
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-app.done:
+				return
+			case <-ticker.C:
+				err := fn()
+				if err != nil {
+					app.logger.PrintError(err, map[string]string{"job": name})
+				}
+			}
+		}
+	})
+}
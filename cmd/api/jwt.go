@@ -0,0 +1,307 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ridwanulhoquejr/todo-app/internal/data"
+	"github.com/ridwanulhoquejr/todo-app/internal/jwt"
+)
+
+// accessClaims are the claims of a self-contained access token. They carry everything
+// authenticate and requirePermission need to know about the user, so they never have
+// to hit the database. A consequence is that changes to the user (e.g. activation or
+// a newly granted role) only show up in the next access token, after a refresh.
+type accessClaims struct {
+	jwt.RegisteredClaims
+	Family    string `json:"fam"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	Activated bool   `json:"act"`
+	// Permissions are the permission codes of the user. They are missing from tokens
+	// signed before they were added, which leaves requirePermission to look them up.
+	Permissions data.Permissions `json:"perms"`
+	// IssuedAtMicro is the issue time in microseconds. iat only has whole seconds,
+	// which isn't enough to tell tokens issued right after a revocation from the
+	// ones issued right before it.
+	IssuedAtMicro int64 `json:"iat_us"`
+}
+
+// user rebuilds the parts of the user known from the claims.
+func (c *accessClaims) user() (*data.User, error) {
+
+	id, err := strconv.ParseInt(c.Subject, 10, 64)
+	if err != nil {
+		return nil, jwt.ErrInvalidToken
+	}
+
+	return &data.User{
+		ID:        id,
+		Name:      c.Name,
+		Email:     c.Email,
+		Activated: c.Activated,
+	}, nil
+}
+
+// signAccessToken issues a signed access token for the user, who holds permissions,
+// in the given family.
+func (app *application) signAccessToken(user *data.User, permissions data.Permissions, family string) (*data.Token, error) {
+
+	jti, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiry := now.Add(accessTokenTTL)
+
+	claims := accessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    app.config.token.jwt.issuer,
+			Subject:   strconv.FormatInt(user.ID, 10),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: expiry.Unix(),
+			ID:        jti,
+		},
+		Family:        family,
+		Name:          user.Name,
+		Email:         user.Email,
+		Activated:     user.Activated,
+		Permissions:   permissions,
+		IssuedAtMicro: now.UnixMicro(),
+	}
+	// an empty list still has to end up in the token, rather than null
+	if claims.Permissions == nil {
+		claims.Permissions = data.Permissions{}
+	}
+
+	plaintext, err := app.config.token.jwt.keys.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &data.Token{
+		Plaintext: plaintext,
+		UserId:    user.ID,
+		Expiry:    time.Unix(expiry.Unix(), 0),
+		Scope:     data.ScopeAuthentication,
+		Family:    family,
+	}, nil
+}
+
+// randomID returns a random, URL safe identifier suitable for the "jti" claim.
+func randomID() (string, error) {
+
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// verifyAccessToken checks the signature, expiry and issuer of an access token, and
+// that it hasn't been revoked.
+func (app *application) verifyAccessToken(token string) (*accessClaims, error) {
+
+	var claims accessClaims
+
+	err := app.config.token.jwt.keys.Parse(token, &claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Issuer != app.config.token.jwt.issuer {
+		return nil, jwt.ErrInvalidToken
+	}
+
+	if app.denylist.revoked(&claims) {
+		return nil, jwt.ErrInvalidToken
+	}
+
+	return &claims, nil
+}
+
+// newTokenPair issues the tokens handed out at login. In JWT mode only the refresh
+// token is stored, while the access token is signed.
+func (app *application) newTokenPair(r *http.Request, user *data.User) (*data.TokenPair, error) {
+
+	if app.config.token.format != tokenFormatJWT {
+		return app.models.Token.NewPair(user.ID, accessTokenTTL, refreshTokenTTL, r.UserAgent(), clientIP(r))
+	}
+
+	pair, err := app.models.Token.NewPair(user.ID, 0, refreshTokenTTL, r.UserAgent(), clientIP(r))
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := app.models.Permission.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	pair.AccessToken, err = app.signAccessToken(user, permissions, pair.RefreshToken.Family)
+	if err != nil {
+		return nil, err
+	}
+
+	return pair, nil
+}
+
+// rotateTokenPair exchanges a refresh token for a new pair, see newTokenPair.
+func (app *application) rotateTokenPair(r *http.Request, refreshToken string) (*data.TokenPair, error) {
+
+	if app.config.token.format != tokenFormatJWT {
+		return app.models.Token.Rotate(refreshToken, accessTokenTTL, refreshTokenTTL, r.UserAgent(), clientIP(r))
+	}
+
+	pair, err := app.models.Token.Rotate(refreshToken, 0, refreshTokenTTL, r.UserAgent(), clientIP(r))
+	if err != nil {
+		return nil, err
+	}
+
+	// the claims need to reflect the current state of the user
+	user, err := app.models.User.Get(pair.RefreshToken.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := app.models.Permission.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	pair.AccessToken, err = app.signAccessToken(user, permissions, pair.RefreshToken.Family)
+	if err != nil {
+		return nil, err
+	}
+
+	return pair, nil
+}
+
+// currentFamily returns the token family (i.e. the session) of the request.
+func (app *application) currentFamily(r *http.Request) (string, error) {
+
+	token := app.contextGetToken(r)
+
+	if jwt.LooksLikeJWT(token) {
+		claims, err := app.verifyAccessToken(token)
+		if err != nil {
+			return "", err
+		}
+		return claims.Family, nil
+	}
+
+	t, err := app.models.Token.Get(data.ScopeAuthentication, token)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	return t.Family, nil
+}
+
+// revokeAccessTokensForUser denies every access token issued to the user so far. It
+// is only needed in JWT mode, as opaque tokens are revoked by deleting their rows.
+func (app *application) revokeAccessTokensForUser(userID int64) error {
+
+	if app.config.token.format != tokenFormatJWT {
+		return nil
+	}
+
+	entry := &data.DenylistEntry{
+		UserID: userID,
+		Expiry: time.Now().Add(accessTokenTTL),
+	}
+
+	err := app.models.Denylist.Insert(entry)
+	if err != nil {
+		return err
+	}
+
+	app.denylist.add(entry)
+	return nil
+}
+
+// denylist is an in-memory copy of the token_denylist table. Entries written by this
+// instance are added straight away, the ones written by other instances show up
+// with the next periodic refresh.
+type denylist struct {
+	mu    sync.RWMutex
+	jtis  map[string]bool
+	users map[int64]time.Time
+}
+
+func newDenylist() *denylist {
+	return &denylist{
+		jtis:  make(map[string]bool),
+		users: make(map[int64]time.Time),
+	}
+}
+
+func (d *denylist) add(entry *data.DenylistEntry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if entry.JTI != "" {
+		d.jtis[entry.JTI] = true
+	}
+	if entry.UserID != 0 && entry.RevokedAt.After(d.users[entry.UserID]) {
+		d.users[entry.UserID] = entry.RevokedAt
+	}
+}
+
+func (d *denylist) revoked(claims *accessClaims) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.jtis[claims.ID] {
+		return true
+	}
+
+	id, _ := strconv.ParseInt(claims.Subject, 10, 64)
+	revokedAt, ok := d.users[id]
+	if !ok {
+		return false
+	}
+
+	// tokens signed before iat_us was added only have whole seconds to go by
+	if claims.IssuedAtMicro == 0 {
+		return claims.IssuedAt <= revokedAt.Unix()
+	}
+	return claims.IssuedAtMicro < revokedAt.UnixMicro()
+}
+
+// refreshDenylist reloads the denylist from the database, dropping expired entries.
+func (app *application) refreshDenylist() error {
+
+	err := app.models.Denylist.DeleteExpired()
+	if err != nil {
+		return err
+	}
+
+	entries, err := app.models.Denylist.GetAll()
+	if err != nil {
+		return err
+	}
+
+	fresh := newDenylist()
+	for _, entry := range entries {
+		fresh.add(entry)
+	}
+
+	app.denylist.mu.Lock()
+	defer app.denylist.mu.Unlock()
+
+	app.denylist.jtis = fresh.jtis
+	app.denylist.users = fresh.users
+
+	return nil
+}
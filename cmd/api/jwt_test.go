@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ridwanulhoquejr/todo-app/internal/data"
+	"github.com/ridwanulhoquejr/todo-app/internal/jsonlog"
+	"github.com/ridwanulhoquejr/todo-app/internal/jwt"
+)
+
+func newJWTApplication(t *testing.T) *application {
+	t.Helper()
+
+	key, err := jwt.NewHS256Key("test", bytes.Repeat([]byte("k"), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := jwt.NewKeySet("test", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config{}
+	cfg.token.format = tokenFormatJWT
+	cfg.token.jwt.issuer = "todo-app"
+	cfg.token.jwt.keys = keys
+
+	return &application{
+		config:   cfg,
+		logger:   jsonlog.New(&bytes.Buffer{}, jsonlog.LevelError),
+		denylist: newDenylist(),
+	}
+}
+
+// authenticateStatus makes a request with the token through authenticate and returns
+// the status of the response.
+func authenticateStatus(app *application, token string) int {
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/users/me", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	w := httptest.NewRecorder()
+	app.authenticate(ok).ServeHTTP(w, r)
+	return w.Code
+}
+
+// TestAccessTokenAfterRevocation covers what changing the password, logging out
+// everywhere and resetting the password do: revoke every access token of the user,
+// then hand out a new one straight away, usually within the same second.
+func TestAccessTokenAfterRevocation(t *testing.T) {
+
+	app := newJWTApplication(t)
+	user := &data.User{ID: 42, Name: "Alice", Email: "alice@example.com", Activated: true}
+
+	before, err := app.signAccessToken(user, nil, "family")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// what DenylistModel.Insert records, without the database
+	time.Sleep(time.Millisecond)
+	app.denylist.add(&data.DenylistEntry{
+		UserID:    user.ID,
+		RevokedAt: time.Now().Truncate(time.Microsecond),
+		Expiry:    time.Now().Add(accessTokenTTL),
+	})
+
+	after, err := app.signAccessToken(user, nil, "family")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status := authenticateStatus(app, before.Plaintext); status != http.StatusUnauthorized {
+		t.Errorf("token issued before the revocation: got status %d, want %d", status, http.StatusUnauthorized)
+	}
+	if status := authenticateStatus(app, after.Plaintext); status != http.StatusOK {
+		t.Errorf("token issued after the revocation: got status %d, want %d", status, http.StatusOK)
+	}
+}
+
+func TestDenylistRevoked(t *testing.T) {
+
+	revokedAt := time.Date(2024, 3, 1, 12, 0, 0, 500_000_000, time.UTC)
+
+	d := newDenylist()
+	d.add(&data.DenylistEntry{JTI: "revoked-jti"})
+	d.add(&data.DenylistEntry{UserID: 1, RevokedAt: revokedAt})
+
+	claims := func(subject, jti string, issuedAt time.Time, micro bool) *accessClaims {
+		c := &accessClaims{RegisteredClaims: jwt.RegisteredClaims{
+			Subject:  subject,
+			ID:       jti,
+			IssuedAt: issuedAt.Unix(),
+		}}
+		if micro {
+			c.IssuedAtMicro = issuedAt.UnixMicro()
+		}
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		claims *accessClaims
+		want   bool
+	}{
+		{"revoked jti", claims("2", "revoked-jti", revokedAt, true), true},
+		{"other user", claims("2", "jti", revokedAt.Add(-time.Hour), true), false},
+		{"issued before", claims("1", "jti", revokedAt.Add(-time.Microsecond), true), true},
+		{"issued at the same time", claims("1", "jti", revokedAt, true), false},
+		{"issued later in the same second", claims("1", "jti", revokedAt.Add(100*time.Millisecond), true), false},
+		{"issued in the next second", claims("1", "jti", revokedAt.Add(time.Second), true), false},
+		{"without iat_us in the same second", claims("1", "jti", revokedAt.Add(100*time.Millisecond), false), true},
+		{"without iat_us in the next second", claims("1", "jti", revokedAt.Add(time.Second), false), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := d.revoked(tt.claims); got != tt.want {
+				t.Errorf("revoked() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRequirePermissionFromClaims checks that the permissions of a signed access
+// token are used as they are. The application has no models, so looking them up in
+// the database would panic.
+func TestRequirePermissionFromClaims(t *testing.T) {
+
+	app := newJWTApplication(t)
+	user := &data.User{ID: 42, Name: "Alice", Email: "alice@example.com", Activated: true}
+
+	tests := []struct {
+		name        string
+		permissions data.Permissions
+		want        int
+	}{
+		{"granted", data.Permissions{data.PermissionTodosRead}, http.StatusOK},
+		{"not granted", data.Permissions{data.PermissionTodosWrite}, http.StatusForbidden},
+		{"no permissions", nil, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			token, err := app.signAccessToken(user, tt.permissions, "family")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			handler := app.authenticate(app.requirePermission(data.PermissionTodosRead)(ok))
+
+			r := httptest.NewRequest(http.MethodGet, "/v1/todos", nil)
+			r.Header.Set("Authorization", "Bearer "+token.Plaintext)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("got status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lockoutPolicy decides when failed logins lock out a key, and for how long. Every
+// failure from the threshold onwards doubles the lockout, up to max.
+type lockoutPolicy struct {
+	threshold int
+	base      time.Duration
+	max       time.Duration
+}
+
+// Accounts are locked out after a handful of failures. An IP address gets a lot more
+// leeway, as many users may share it, but it stops one client from spraying
+// passwords across every account.
+var (
+	accountLockout = lockoutPolicy{threshold: 5, base: time.Minute, max: time.Hour}
+	ipLockout      = lockoutPolicy{threshold: 20, base: time.Minute, max: time.Hour}
+)
+
+// loginFailureWindow is how long failures are remembered. A key which hasn't failed
+// for that long starts over from zero.
+const loginFailureWindow = 24 * time.Hour
+
+// lockout returns how long the key is locked out after its nth failure.
+func (p lockoutPolicy) lockout(failures int) time.Duration {
+
+	if failures < p.threshold {
+		return 0
+	}
+
+	exp := failures - p.threshold
+	if exp > 30 {
+		return p.max
+	}
+
+	d := p.base * time.Duration(math.Pow(2, float64(exp)))
+	if d > p.max {
+		return p.max
+	}
+	return d
+}
+
+// The keys under which failures are counted. Emails are counted whether or not the
+// account exists, so that the lockout doesn't reveal which ones do.
+func accountLoginKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+func ipLoginKey(r *http.Request) string {
+	return "ip:" + clientIP(r)
+}
+
+// checkLoginLockout sends a 429 response and returns false if either the account or
+// the client IP address is locked out.
+func (app *application) checkLoginLockout(w http.ResponseWriter, r *http.Request, email string) bool {
+
+	lockedUntil, err := app.models.Login.LockedUntil(accountLoginKey(email), ipLoginKey(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !lockedUntil.IsZero() {
+		app.tooManyLoginAttemptsResponse(w, r, time.Until(lockedUntil))
+		return false
+	}
+
+	return true
+}
+
+// recordLoginFailure counts a failed login for both the account and the client IP
+// address, locking out whichever went over its threshold. The caller is about to
+// reject the login anyway, so errors are only logged.
+func (app *application) recordLoginFailure(r *http.Request, email string) {
+
+	err := app.countLoginFailure(r, email)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+func (app *application) countLoginFailure(r *http.Request, email string) error {
+
+	keys := []struct {
+		key    string
+		policy lockoutPolicy
+	}{
+		{accountLoginKey(email), accountLockout},
+		{ipLoginKey(r), ipLockout},
+	}
+
+	for _, k := range keys {
+		failures, err := app.models.Login.RecordFailure(k.key, loginFailureWindow)
+		if err != nil {
+			return err
+		}
+
+		d := k.policy.lockout(failures)
+		if d == 0 {
+			continue
+		}
+
+		lockedUntil := time.Now().Add(d)
+
+		err = app.models.Login.Lock(k.key, lockedUntil)
+		if err != nil {
+			return err
+		}
+
+		app.logger.PrintInfo("login locked out", map[string]string{
+			"key":          k.key,
+			"failures":     strconv.Itoa(failures),
+			"locked_until": lockedUntil.Format(time.RFC3339),
+			"ip_address":   clientIP(r),
+			"user_agent":   r.UserAgent(),
+		})
+	}
+
+	return nil
+}
+
+// resetLoginFailures is called after a successful login. Only the account is reset:
+// the failures of the IP address keep counting, otherwise an attacker could clear
+// them by logging into an account of their own every now and then.
+func (app *application) resetLoginFailures(email string) error {
+	return app.models.Login.Reset(accountLoginKey(email))
+}
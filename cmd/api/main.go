@@ -4,10 +4,18 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sync"
+	// embed the time zone database, so that users' time zones resolve even on
+	// hosts without one installed
+	_ "time/tzdata"
 
 	"github.com/ridwanulhoquejr/todo-app/internal/data"
 	"github.com/ridwanulhoquejr/todo-app/internal/db"
 	"github.com/ridwanulhoquejr/todo-app/internal/jsonlog"
+	"github.com/ridwanulhoquejr/todo-app/internal/mailer"
+	"github.com/ridwanulhoquejr/todo-app/internal/notify"
+	"github.com/ridwanulhoquejr/todo-app/internal/oidc"
+	"github.com/ridwanulhoquejr/todo-app/internal/secret"
 )
 
 // Define an application struct to hold the dependencies for our HTTP handlers, helpers,
@@ -17,6 +25,19 @@ type application struct {
 	config *config
 	logger *jsonlog.Logger
 	models *data.Models
+	mailer mailer.Mailer
+	// wg tracks the background go-routines (e.g. sending emails), so that
+	// serve() can wait for them to finish before the application exits
+	wg sync.WaitGroup
+	// done is closed when the server shuts down, to stop the periodic jobs
+	done     chan struct{}
+	denylist *denylist
+	// secrets encrypts the TOTP secrets, it is nil when no key is configured
+	secrets *secret.Box
+	// oidc holds the identity providers users can log in with, by name
+	oidc map[string]*oidc.Provider
+	// channels deliver the reminders, by the name of the channel
+	channels map[string]notify.Channel
 }
 
 func main() {
@@ -50,10 +71,42 @@ func main() {
 	}
 	fmt.Println("Succesfully ping the database")
 
+	cfg := Configs()
+
+	m, err := newMailer(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+		return
+	}
+
+	var secrets *secret.Box
+	if cfg.totp.key != nil {
+		secrets, err = secret.New(cfg.totp.key)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+			return
+		}
+	}
+
+	providers := make(map[string]*oidc.Provider)
+	for _, p := range cfg.oidc {
+		providers[p.Name] = oidc.New(p)
+	}
+
 	app := &application{
-		config: Configs(),
-		logger: logger,
-		models: data.NewModels(db.DB),
+		config:   cfg,
+		logger:   logger,
+		models:   data.NewModels(db.DB),
+		mailer:   m,
+		done:     make(chan struct{}),
+		denylist: newDenylist(),
+		secrets:  secrets,
+		oidc:     providers,
+		channels: map[string]notify.Channel{
+			"email":   &notify.Email{Mailer: m},
+			"webhook": notify.NewWebhook(reminderSendTimeout),
+			"log":     &notify.Log{Logger: logger},
+		},
 	}
 
 	err = app.serve()
@@ -62,3 +115,14 @@ func main() {
 		return
 	}
 }
+
+// newMailer picks the mail backend based on the config. Anything other than "smtp"
+// falls back to the file mailer, so that dev setups never send real email by accident.
+func newMailer(cfg *config) (mailer.Mailer, error) {
+	switch cfg.mailer {
+	case "smtp":
+		return mailer.NewSMTP(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender), nil
+	default:
+		return mailer.NewFile(cfg.mailDir, cfg.smtp.sender)
+	}
+}
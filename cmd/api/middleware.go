@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/ridwanulhoquejr/todo-app/internal/data"
+	"github.com/ridwanulhoquejr/todo-app/internal/jwt"
 	"github.com/ridwanulhoquejr/todo-app/internal/validator"
 )
 
@@ -35,15 +36,73 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// extract the actuatl token
 		token := headerParts[1]
 
+		// In JWT mode, signed access tokens carry the user with them, so they are
+		// verified without a database lookup. Opaque tokens issued before switching
+		// modes keep working through the regular path below.
+		if app.config.token.format == tokenFormatJWT && jwt.LooksLikeJWT(token) {
+
+			claims, err := app.verifyAccessToken(token)
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			user, err := claims.user()
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetToken(r, token)
+			if claims.Permissions != nil {
+				r = app.contextSetPermissions(r, claims.Permissions)
+			}
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		// Validate the token to make sure it is in a sensible format.
 		v := validator.New()
 
-		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
-			app.invalidAuthenticationTokenResponse(w, r)
-			return
+		var user *data.User
+		var scoped *data.Token
+		var err error
+
+		switch {
+		// Personal access, impersonation and OAuth tokens are recognised by their prefix.
+		// Unlike session tokens, they are restricted to the scopes they were
+		// created with.
+		case strings.HasPrefix(token, data.PersonalAccessTokenPrefix):
+			if data.ValidatePrefixedTokenPlaintext(v, token, data.PersonalAccessTokenPrefix); !v.Valid() {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+			user, scoped, err = app.getScopedTokenUser(data.ScopePersonalAccess, token)
+
+		case strings.HasPrefix(token, data.ImpersonationTokenPrefix):
+			if data.ValidatePrefixedTokenPlaintext(v, token, data.ImpersonationTokenPrefix); !v.Valid() {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+			user, scoped, err = app.getScopedTokenUser(data.ScopeImpersonation, token)
+
+		case strings.HasPrefix(token, data.OAuthAccessTokenPrefix):
+			if data.ValidatePrefixedTokenPlaintext(v, token, data.OAuthAccessTokenPrefix); !v.Valid() {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+			user, scoped, err = app.getScopedTokenUser(data.ScopeOAuthAccess, token)
+
+		default:
+			if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+			user, err = app.models.User.GetByToken(data.ScopeAuthentication, token)
 		}
 
-		user, err := app.models.User.GetByToken(data.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -54,9 +113,106 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		// every request made while impersonating a user ends up in the audit log
+		if scoped != nil && scoped.ImpersonatorID != 0 {
+			err = app.models.Audit.Insert(&data.AuditEvent{
+				ActorID:      scoped.ImpersonatorID,
+				Action:       data.AuditImpersonatedRequest,
+				TargetUserID: user.ID,
+				Details: map[string]string{
+					"request_method": r.Method,
+					"request_url":    r.URL.String(),
+				},
+				IPAddress: clientIP(r),
+			})
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		// record the usage of the token for the user's session list. A failure here
+		// shouldn't stop the request, so we only log it
+		err = app.models.Token.Touch(token, r.UserAgent(), clientIP(r))
+		if err != nil {
+			app.logError(r, err)
+		}
+
 		// Call the contextSetUser() helper to add the user information to the request
 		// context.
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, token)
+		if scoped != nil {
+			r = app.contextSetScopes(r, scoped.APIScopes)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requirePermission rejects requests from users who don't hold the permission code.
+// The permissions come from the access token when it carries them, and from the
+// database otherwise. API scopes use the same codes as permissions, so if the request
+// was made with a scoped token, the token must have been granted the code as well. It
+// must be used after authenticate.
+func (app *application) requirePermission(code string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			permissions, ok := app.contextGetPermissions(r)
+			if !ok {
+				user := app.contextGetUser(r)
+
+				var err error
+				permissions, err = app.models.Permission.GetAllForUser(user.ID)
+				if err != nil {
+					app.serverErrorResponse(w, r, err)
+					return
+				}
+			}
+
+			if !permissions.Include(code) {
+				app.notPermittedResponse(w, r)
+				return
+			}
+
+			scopes, restricted := app.contextGetScopes(r)
+
+			if restricted && !validator.In(code, scopes...) {
+				app.insufficientScopeResponse(w, r, code)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireFullAccess rejects requests made with a scoped token. It guards the routes
+// which manage credentials, so that e.g. a leaked API key can't mint new ones.
+func (app *application) requireFullAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if _, restricted := app.contextGetScopes(r); restricted {
+			app.insufficientScopeResponse(w, r, "")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireActivatedUser must be used after authenticate, it relies on the user
+// being present in the request context.
+func (app *application) requireActivatedUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		user := app.contextGetUser(r)
+
+		if !user.Activated {
+			app.inactiveAccountResponse(w, r)
+			return
+		}
 
 		next.ServeHTTP(w, r)
 	})
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ridwanulhoquejr/todo-app/internal/data"
+	"github.com/ridwanulhoquejr/todo-app/internal/oidc"
+	"github.com/ridwanulhoquejr/todo-app/internal/validator"
+)
+
+// Lifetimes of what we hand out to OAuth clients. Codes only have to survive the
+// redirect back to the client.
+const (
+	oauthCodeTTL         = 5 * time.Minute
+	oauthAccessTokenTTL  = time.Hour
+	oauthRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var errInvalidClient = errors.New("invalid client")
+
+// oauthError is the error response of the token, introspection and revocation
+// endpoints, as defined by RFC 6749 section 5.2.
+type oauthError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// writeOAuthJSON writes the responses of the endpoints OAuth clients talk to. These
+// are defined by the RFCs, so they are not wrapped in our APIResponse.
+func (app *application) writeOAuthJSON(w http.ResponseWriter, status int, data any) error {
+
+	js, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(js)
+
+	return nil
+}
+
+func (app *application) oauthErrorResponse(w http.ResponseWriter, r *http.Request, status int, code, description string) {
+
+	if code == "invalid_client" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+
+	err := app.writeOAuthJSON(w, status, oauthError{Error: code, Description: description})
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(status)
+	}
+}
+
+// authenticateOAuthClient identifies the client calling the token, introspection or
+// revocation endpoint, using HTTP basic authentication or the client_id and
+// client_secret form parameters. Public clients only send their client_id.
+func (app *application) authenticateOAuthClient(r *http.Request) (*data.OAuthClient, error) {
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		// RFC 6749 section 2.3.1: both are form encoded before being put in the header
+		var err error
+		if clientID, err = url.QueryUnescape(clientID); err != nil {
+			return nil, errInvalidClient
+		}
+		if clientSecret, err = url.QueryUnescape(clientSecret); err != nil {
+			return nil, errInvalidClient
+		}
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	if clientID == "" {
+		return nil, errInvalidClient
+	}
+
+	client, err := app.models.OAuthClient.Get(clientID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, errInvalidClient
+		default:
+			return nil, err
+		}
+	}
+
+	if client.Confidential != (clientSecret != "") {
+		return nil, errInvalidClient
+	}
+	if client.Confidential && !client.SecretMatches(clientSecret) {
+		return nil, errInvalidClient
+	}
+
+	return client, nil
+}
+
+// readOAuthForm parses the form encoded body of a request from an OAuth client, and
+// authenticates the client. If either fails the error response has already been sent
+// and nil is returned.
+func (app *application) readOAuthForm(w http.ResponseWriter, r *http.Request) *data.OAuthClient {
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+
+	err := r.ParseForm()
+	if err != nil {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "malformed form body")
+		return nil
+	}
+
+	client, err := app.authenticateOAuthClient(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidClient):
+			app.oauthErrorResponse(w, r, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+
+	return client
+}
+
+func (app *application) createOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	var payload struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Confidential bool     `json:"confidential"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	client := &data.OAuthClient{
+		Name:         payload.Name,
+		RedirectURIs: payload.RedirectURIs,
+		Confidential: payload.Confidential,
+		UserID:       user.ID,
+	}
+
+	v := validator.New()
+
+	if data.ValidateOAuthClient(v, client); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.OAuthClient.Insert(client)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// this is the only time the client secret is ever shown
+	err = app.writeJSON(w, http.StatusCreated, client, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) listOAuthClientsHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	clients, err := app.models.OAuthClient.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"clients": clients}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// deleteOAuthClientHandler removes a client, which revokes every token issued to it.
+func (app *application) deleteOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	err := app.models.OAuthClient.Delete(chi.URLParam(r, "id"), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "client successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// authorizationRequest holds the parameters of RFC 6749 section 4.1.1, plus the PKCE
+// ones of RFC 7636. PKCE is required from every client.
+type authorizationRequest struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+// validate checks the request, and returns the client and the requested scopes.
+// Without a scope parameter the client gets read access only.
+func (req *authorizationRequest) validate(app *application, v *validator.Validator) (*data.OAuthClient, []string, error) {
+
+	client, err := app.models.OAuthClient.Get(req.ClientID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("client_id", "must be a registered client")
+			return nil, nil, nil
+		default:
+			return nil, nil, err
+		}
+	}
+
+	// the redirect URI may only be left out if there's no doubt about it
+	if req.RedirectURI == "" && len(client.RedirectURIs) == 1 {
+		req.RedirectURI = client.RedirectURIs[0]
+	}
+
+	v.Check(client.HasRedirectURI(req.RedirectURI), "redirect_uri", "must be registered for the client")
+	v.Check(req.ResponseType == "code", "response_type", "must be code")
+	v.Check(req.CodeChallenge != "", "code_challenge", "must be provided")
+	v.Check(req.CodeChallengeMethod == "S256", "code_challenge_method", "must be S256")
+
+	scopes := strings.Fields(req.Scope)
+	if len(scopes) == 0 {
+		scopes = []string{data.PermissionTodosRead}
+	}
+	data.ValidateOAuthScopes(v, scopes)
+
+	return client, scopes, nil
+}
+
+// authorizeInfoHandler backs the consent screen: it checks the authorization request
+// the client sent the user with, and describes what the user is asked to agree to.
+func (app *application) authorizeInfoHandler(w http.ResponseWriter, r *http.Request) {
+
+	qs := r.URL.Query()
+
+	req := authorizationRequest{
+		ResponseType:        qs.Get("response_type"),
+		ClientID:            qs.Get("client_id"),
+		RedirectURI:         qs.Get("redirect_uri"),
+		Scope:               qs.Get("scope"),
+		State:               qs.Get("state"),
+		CodeChallenge:       qs.Get("code_challenge"),
+		CodeChallengeMethod: qs.Get("code_challenge_method"),
+	}
+
+	v := validator.New()
+
+	client, scopes, err := req.validate(app, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	res := envelope{
+		"client":       envelope{"client_id": client.ID, "name": client.Name},
+		"scopes":       scopes,
+		"redirect_uri": req.RedirectURI,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, res, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// authorizeHandler records the decision of the user on the consent screen. Either
+// way the response tells the frontend where to send the user back to: with a code
+// if they approved, or with an access_denied error if they didn't.
+func (app *application) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	var payload struct {
+		authorizationRequest
+		Approve bool `json:"approve"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	req := payload.authorizationRequest
+	v := validator.New()
+
+	client, scopes, err := req.validate(app, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// req.validate() made sure the URI is one the client registered
+	redirect, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	params := redirect.Query()
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+
+	if !payload.Approve {
+		params.Set("error", "access_denied")
+	} else {
+		code := &data.AuthorizationCode{
+			ClientID:      client.ID,
+			UserID:        user.ID,
+			RedirectURI:   req.RedirectURI,
+			Scopes:        scopes,
+			CodeChallenge: req.CodeChallenge,
+		}
+
+		err = app.models.OAuthCode.New(code, oauthCodeTTL)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		params.Set("code", code.Plaintext)
+	}
+
+	redirect.RawQuery = params.Encode()
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"redirect_to": redirect.String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// oauthTokenHandler is the token endpoint of RFC 6749 section 3.2, supporting the
+// authorization_code and refresh_token grants.
+func (app *application) oauthTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	client := app.readOAuthForm(w, r)
+	if client == nil {
+		return
+	}
+
+	var pair *data.TokenPair
+	var err error
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code, err := app.models.OAuthCode.Consume(r.PostForm.Get("code"), client.ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "invalid or expired authorization code")
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		challenge := oidc.CodeChallenge(r.PostForm.Get("code_verifier"))
+
+		if code.RedirectURI != r.PostForm.Get("redirect_uri") ||
+			subtle.ConstantTimeCompare([]byte(challenge), []byte(code.CodeChallenge)) != 1 {
+			app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "redirect_uri or code_verifier mismatch")
+			return
+		}
+
+		pair, err = app.models.Token.NewOAuthPair(code.UserID, client.ID, code.Scopes, oauthAccessTokenTTL, oauthRefreshTokenTTL)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+	case "refresh_token":
+		pair, err = app.models.Token.RotateOAuth(r.PostForm.Get("refresh_token"), client.ID, oauthAccessTokenTTL, oauthRefreshTokenTTL)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "invalid or expired refresh token")
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+	default:
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "unsupported_grant_type", "")
+		return
+	}
+
+	res := struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+	}{
+		AccessToken:  pair.AccessToken.Plaintext,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(oauthAccessTokenTTL.Seconds()),
+		RefreshToken: pair.RefreshToken.Plaintext,
+		Scope:        strings.Join(pair.AccessToken.APIScopes, " "),
+	}
+
+	err = app.writeOAuthJSON(w, http.StatusOK, res)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// introspectHandler implements RFC 7662. A client may only introspect the tokens
+// issued to it; any other token is reported as inactive, like an unknown one.
+func (app *application) introspectHandler(w http.ResponseWriter, r *http.Request) {
+
+	client := app.readOAuthForm(w, r)
+	if client == nil {
+		return
+	}
+
+	scopes := []string{data.ScopeOAuthAccess, data.ScopeOAuthRefresh}
+	if r.PostForm.Get("token_type_hint") == "refresh_token" {
+		scopes[0], scopes[1] = scopes[1], scopes[0]
+	}
+
+	var token *data.Token
+
+	for _, scope := range scopes {
+		t, err := app.models.Token.Get(scope, r.PostForm.Get("token"))
+		if err == nil {
+			token = t
+			break
+		}
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if token == nil || token.ClientID != client.ID {
+		err := app.writeOAuthJSON(w, http.StatusOK, envelope{"active": false})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.User.Get(token.UserId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	res := envelope{
+		"active":    true,
+		"scope":     strings.Join(token.APIScopes, " "),
+		"client_id": token.ClientID,
+		"username":  user.Email,
+		"sub":       strconv.FormatInt(user.ID, 10),
+		"exp":       token.Expiry.Unix(),
+		"iat":       token.CreationTime.Unix(),
+	}
+	if token.Scope == data.ScopeOAuthAccess {
+		res["token_type"] = "Bearer"
+	}
+
+	err = app.writeOAuthJSON(w, http.StatusOK, res)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// revokeHandler implements RFC 7009. Revoking either token of a grant revokes both.
+// Unknown tokens are not an error, the client can't do anything about them anyway.
+func (app *application) revokeHandler(w http.ResponseWriter, r *http.Request) {
+
+	client := app.readOAuthForm(w, r)
+	if client == nil {
+		return
+	}
+
+	err := app.models.Token.RevokeOAuth(r.PostForm.Get("token"), client.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ridwanulhoquejr/todo-app/internal/data"
+	"github.com/ridwanulhoquejr/todo-app/internal/oidc"
+)
+
+// oidcLoginStateTTL is how long the user has to log in at the identity provider.
+const oidcLoginStateTTL = 10 * time.Minute
+
+var errUnverifiedEmail = errors.New("the identity provider hasn't verified the email address")
+
+// oidcLoginHandler starts a login with an external identity provider, by redirecting
+// the user to it. The state, nonce and PKCE verifier are kept on our side until the
+// provider sends the user back to the callback.
+func (app *application) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+
+	provider, ok := app.oidc[chi.URLParam(r, "provider")]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	state := data.LoginState{
+		Provider: provider.Config.Name,
+		Expiry:   time.Now().Add(oidcLoginStateTTL),
+	}
+
+	for _, s := range []*string{&state.State, &state.Nonce, &state.CodeVerifier} {
+		var err error
+		*s, err = oidc.RandomString()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err := app.models.LoginState.Insert(&state)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	url, err := provider.AuthCodeURL(r.Context(), state.State, state.Nonce, oidc.CodeChallenge(state.CodeVerifier))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// oidcCallbackHandler completes the login: it exchanges the authorization code, finds
+// or creates the user of the external account and hands out a token pair, exactly
+// like a password login does.
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+
+	provider, ok := app.oidc[chi.URLParam(r, "provider")]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	qs := r.URL.Query()
+
+	// the user cancelled, or the provider refused the login
+	if e := qs.Get("error"); e != "" {
+		app.errorResponse(w, r, http.StatusUnauthorized, "login at the identity provider failed: "+e)
+		return
+	}
+
+	if qs.Get("state") == "" || qs.Get("code") == "" {
+		app.badRequestResponse(w, r, errors.New("missing state or code parameter"))
+		return
+	}
+
+	state, err := app.models.LoginState.Consume(provider.Config.Name, qs.Get("state"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.badRequestResponse(w, r, errors.New("invalid or expired login state"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	claims, err := provider.Exchange(r.Context(), qs.Get("code"), state.CodeVerifier, state.Nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrExchangeFailed), errors.Is(err, oidc.ErrInvalidIDToken):
+			app.logError(r, err)
+			app.errorResponse(w, r, http.StatusUnauthorized, "login at the identity provider failed")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.userForIdentity(provider.Config.Name, claims)
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnverifiedEmail):
+			app.errorResponse(w, r, http.StatusForbidden, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	enabled, err := app.totpEnabled(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if enabled {
+		app.mfaRequiredResponse(w, r, user)
+		return
+	}
+
+	pair, err := app.newTokenPair(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, pair, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// userForIdentity returns the user linked to the external account. An account seen
+// for the first time is linked to the user with the same email address, or to a
+// brand new user if there is none; either way only if the provider has verified
+// the address, otherwise anyone could take over an account by claiming its email.
+func (app *application) userForIdentity(provider string, claims *oidc.Claims) (*data.User, error) {
+
+	user, err := app.models.Identity.GetUser(provider, claims.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errUnverifiedEmail
+	}
+
+	user, err = app.models.User.GetByEmail(claims.Email)
+	if err != nil {
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			return nil, err
+		}
+
+		user, err = app.createIdentityUser(claims)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	identity := &data.Identity{
+		UserID:   user.ID,
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+
+	err = app.models.Identity.Insert(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// createIdentityUser creates the user for an external account. The email address has
+// been verified by the provider, so the user is activated right away. They get a
+// random password which nobody knows, a password reset sets a real one.
+func (app *application) createIdentityUser(claims *oidc.Claims) (*data.User, error) {
+
+	name := claims.Name
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	user := &data.User{
+		Name:      name,
+		Email:     claims.Email,
+		Activated: true,
+	}
+
+	random, err := oidc.RandomString()
+	if err != nil {
+		return nil, err
+	}
+
+	err = user.Password.Set(random)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.User.Insert(user, data.RoleMember)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
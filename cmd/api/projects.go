@@ -0,0 +1,293 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ridwanulhoquejr/todo-app/internal/data"
+	"github.com/ridwanulhoquejr/todo-app/internal/validator"
+)
+
+// readProjectParam looks up the project of the {id} URL parameter among the projects
+// of the authenticated user. If it can't be found, an error response has already been
+// sent and false is returned.
+func (app *application) readProjectParam(w http.ResponseWriter, r *http.Request) (*data.Project, bool) {
+
+	user := app.contextGetUser(r)
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil, false
+	}
+
+	if id <= 0 {
+		app.badRequestResponse(w, r, errInvalidPathParam)
+		return nil, false
+	}
+
+	project, err := app.models.Project.Get(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return project, true
+}
+
+// checkTodoProject checks that a todo is being put into a project of the user which
+// isn't archived; a nil project is the inbox.
+func (app *application) checkTodoProject(v *validator.Validator, userID int64, projectID *int64) error {
+
+	if projectID == nil {
+		return nil
+	}
+
+	project, err := app.models.Project.Get(*projectID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("project_id", "must be one of your projects")
+			return nil
+		default:
+			return err
+		}
+	}
+
+	v.Check(!project.Archived, "project_id", "must not be an archived project")
+	return nil
+}
+
+// listProjectsHandler lists the user's projects; archived ones only with
+// archived=true.
+func (app *application) listProjectsHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	v := validator.New()
+	withArchived := app.readBoolean(r.URL.Query(), "archived", false, v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	projects, err := app.models.Project.GetAllForUser(user.ID, withArchived)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"projects": projects}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) createProjectHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	var payload struct {
+		Name     string `json:"name"`
+		Color    string `json:"color"`
+		Position *int32 `json:"position"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	project := &data.Project{
+		UserID: user.ID,
+		Name:   payload.Name,
+		Color:  payload.Color,
+	}
+	if project.Color == "" {
+		project.Color = data.DefaultColor
+	}
+	if payload.Position != nil {
+		project.Position = *payload.Position
+	}
+
+	v := validator.New()
+
+	if data.ValidateProject(v, project); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// without a position the project goes at the end of the list
+	err = app.models.Project.Insert(project, payload.Position == nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/projects/%d", project.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, project, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) getProjectHandler(w http.ResponseWriter, r *http.Request) {
+
+	project, ok := app.readProjectParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, project, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) updateProjectHandler(w http.ResponseWriter, r *http.Request) {
+
+	project, ok := app.readProjectParam(w, r)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		Name     *string `json:"name"`
+		Color    *string `json:"color"`
+		Archived *bool   `json:"archived"`
+		Position *int32  `json:"position"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if payload.Name != nil {
+		project.Name = *payload.Name
+	}
+	if payload.Color != nil {
+		project.Color = *payload.Color
+	}
+	if payload.Archived != nil {
+		project.Archived = *payload.Archived
+	}
+	if payload.Position != nil {
+		project.Position = *payload.Position
+	}
+
+	v := validator.New()
+
+	if data.ValidateProject(v, project); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Project.Update(project)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, project, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// deleteProjectHandler deletes a project. By default its todos are moved to the inbox;
+// with todos=delete they are deleted as well.
+func (app *application) deleteProjectHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if id <= 0 {
+		app.badRequestResponse(w, r, errInvalidPathParam)
+		return
+	}
+
+	v := validator.New()
+
+	todos := app.readString(r.URL.Query(), "todos", "inbox")
+	if v.Check(validator.In(todos, "inbox", "delete"), "todos", "must be inbox or delete"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Project.Delete(id, user.ID, todos == "delete")
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "project successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// listProjectTodosHandler lists the todos of a project, taking the same query
+// parameters as getAllTodoHandler.
+func (app *application) listProjectTodosHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	project, ok := app.readProjectParam(w, r)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+	queries := app.getQueries(r, v)
+	queries.Filters.ProjectID = project.ID
+	queries.Filters.Inbox = false
+
+	if data.ValidateQueries(v, queries); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	todos, metadata, err := app.models.Todo.GetAll(user.ID, queries)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"todo": todos, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ridwanulhoquejr/todo-app/internal/data"
+	"github.com/ridwanulhoquejr/todo-app/internal/rrule"
+	"github.com/ridwanulhoquejr/todo-app/internal/validator"
+)
+
+// normalizeRecurrence fills in the time zone of a recurrence from a payload, which
+// defaults to the user's, and formats a valid rule the way it's stored.
+func (app *application) normalizeRecurrence(r *http.Request, recurrence *data.Recurrence) error {
+
+	if recurrence.TimeZone == "" {
+		user, err := app.currentUser(r)
+		if err != nil {
+			return err
+		}
+		recurrence.TimeZone = user.TimeZone
+	}
+
+	if rule, err := rrule.Parse(recurrence.RRule); err == nil {
+		recurrence.RRule = rule.String()
+	}
+	return nil
+}
+
+// listOccurrencesHandler previews the next occurrences of a recurring todo, after the
+// current one; count=N asks for up to N of them.
+func (app *application) listOccurrencesHandler(w http.ResponseWriter, r *http.Request) {
+
+	todo, ok := app.readTodoParam(w, r)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+
+	count := app.readInt(r.URL.Query(), "count", 5, v)
+	v.Check(count > 0, "count", "must be greater than zero")
+	v.Check(count <= 100, "count", "must be a maximum of 100")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	occurrences, err := todo.Occurrences(count)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"occurrences": occurrences}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// skipOccurrenceHandler skips the current occurrence of a recurring todo, by moving it
+// on to the next one without completing it.
+func (app *application) skipOccurrenceHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	todo, ok := app.readTodoParam(w, r)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(todo.Recurrence != nil, "recurrence", "todo is not recurring"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	next, err := todo.NextOccurrence()
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrSeriesEnded):
+			v.AddError("recurrence", "has no more occurrences to skip to")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	todo.DueAt = next.DueAt
+	todo.Recurrence = next.Recurrence
+
+	err = app.models.Todo.Update(user.ID, todo)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, todo, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
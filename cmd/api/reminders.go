@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ridwanulhoquejr/todo-app/internal/data"
+	"github.com/ridwanulhoquejr/todo-app/internal/notify"
+	"github.com/ridwanulhoquejr/todo-app/internal/validator"
+)
+
+const (
+	// reminderBatchSize is how many reminders are claimed at once. It's kept small so
+	// that a batch is delivered well before its reminders count as stuck.
+	reminderBatchSize = 10
+	// reminderMaxAttempts is how many times delivering a reminder is tried before
+	// giving up on it.
+	reminderMaxAttempts = 5
+	// reminderRetryBackoff is the wait before the first retry, it doubles with every
+	// attempt after that.
+	reminderRetryBackoff = time.Minute
+	// reminderSendTimeout limits how long a single delivery may take.
+	reminderSendTimeout = 15 * time.Second
+)
+
+func (app *application) listRemindersHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	todo, ok := app.readTodoParam(w, r)
+	if !ok {
+		return
+	}
+
+	reminders, err := app.models.Reminder.GetAllForTodo(todo.ID, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"reminders": reminders}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// createReminderHandler adds a reminder to a todo, either at a fixed remind_at time or
+// offset_minutes from its due date. The channel defaults to email.
+func (app *application) createReminderHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	todo, ok := app.readTodoParam(w, r)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		RemindAt      *time.Time `json:"remind_at"`
+		OffsetMinutes *int32     `json:"offset_minutes"`
+		Channel       string     `json:"channel"`
+		WebhookURL    string     `json:"webhook_url"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	reminder := &data.Reminder{
+		TodoID:        todo.ID,
+		UserID:        user.ID,
+		RemindAt:      payload.RemindAt,
+		OffsetMinutes: payload.OffsetMinutes,
+		Channel:       payload.Channel,
+		WebhookURL:    payload.WebhookURL,
+	}
+	if reminder.Channel == "" {
+		reminder.Channel = "email"
+	}
+
+	v := validator.New()
+
+	if data.ValidateReminder(v, reminder, todo); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Reminder.Insert(reminder)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/todos/%d/reminders/%d", todo.ID, reminder.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, reminder, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) deleteReminderHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	todoID, err := app.readIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "reminderID"), 10, 64)
+	if err != nil || todoID <= 0 || id <= 0 {
+		app.badRequestResponse(w, r, errInvalidPathParam)
+		return
+	}
+
+	err = app.models.Reminder.Delete(id, todoID, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "reminder successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// sendDueReminders delivers the reminders which are due, a batch at a time. It runs as
+// a periodic job. When the application shuts down, the reminder being delivered is
+// finished and the rest of the batch is handed back for the next run.
+func (app *application) sendDueReminders() error {
+
+	for {
+		select {
+		case <-app.done:
+			return nil
+		default:
+		}
+
+		reminders, err := app.models.Reminder.ClaimDue(reminderBatchSize)
+		if err != nil {
+			return err
+		}
+
+		if len(reminders) == 0 {
+			return nil
+		}
+
+		for i, reminder := range reminders {
+			select {
+			case <-app.done:
+				ids := make([]int64, 0, len(reminders)-i)
+				for _, rest := range reminders[i:] {
+					ids = append(ids, rest.ID)
+				}
+				return app.models.Reminder.Release(ids)
+			default:
+			}
+
+			err := app.sendReminder(reminder)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sendReminder delivers a single reminder over its channel. Failed deliveries are
+// retried with an exponential backoff, until reminderMaxAttempts is reached.
+func (app *application) sendReminder(reminder *data.DueReminder) error {
+
+	channel, ok := app.channels[reminder.Channel]
+	if !ok {
+		return app.models.Reminder.Fail(reminder.ID, "unknown channel "+reminder.Channel)
+	}
+
+	n := &notify.Notification{
+		Email:      reminder.Email,
+		Name:       reminder.Name,
+		TodoID:     reminder.TodoID,
+		Title:      reminder.Title,
+		DueAt:      reminder.DueAt,
+		WebhookURL: reminder.WebhookURL,
+	}
+	if reminder.DueAt != nil {
+		user := data.User{TimeZone: reminder.TimeZone}
+		due := reminder.DueAt.In(user.Location())
+		if reminder.AllDay {
+			n.DueText = due.Format("Monday, 2 January 2006")
+		} else {
+			n.DueText = due.Format("Monday, 2 January 2006 15:04 MST")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), reminderSendTimeout)
+	defer cancel()
+
+	err := channel.Send(ctx, n)
+	if err == nil {
+		return app.models.Reminder.MarkSent(reminder.ID)
+	}
+
+	app.logger.PrintError(err, map[string]string{
+		"reminder_id": strconv.FormatInt(reminder.ID, 10),
+		"channel":     reminder.Channel,
+		"attempt":     strconv.Itoa(reminder.Attempts),
+	})
+
+	if reminder.Attempts >= reminderMaxAttempts {
+		return app.models.Reminder.Fail(reminder.ID, err.Error())
+	}
+
+	backoff := reminderRetryBackoff << (reminder.Attempts - 1)
+	return app.models.Reminder.Retry(reminder.ID, err.Error(), time.Now().Add(backoff))
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/ridwanulhoquejr/todo-app/internal/data"
 )
 
 func (app *application) routes() http.Handler {
@@ -21,22 +22,127 @@ func (app *application) routes() http.Handler {
 	// todo routes group
 	r.Route("/todos", func(r chi.Router) {
 		r.Use(app.authenticate)
-		r.Get("/all", app.getAllTodoHandler)
-		r.Get("/{id}", app.getTodoHandler)
-		r.Patch("/{id}", app.updateTodoHandler)
-		r.Delete("/{id}", app.deleteTodoHandler)
-		r.Post("/", app.createTodoHandler)
+		r.Use(app.requireActivatedUser)
+		r.With(app.requirePermission(data.PermissionTodosRead)).Get("/all", app.getAllTodoHandler)
+		r.With(app.requirePermission(data.PermissionTodosRead)).Get("/trash", app.listTrashHandler)
+		r.With(app.requirePermission(data.PermissionTodosWrite)).Delete("/trash/{id}", app.deleteTodoPermanentlyHandler)
+		r.With(app.requirePermission(data.PermissionTodosRead)).Get("/{id}", app.getTodoHandler)
+		r.With(app.requirePermission(data.PermissionTodosRead)).Get("/{id}/occurrences", app.listOccurrencesHandler)
+		r.With(app.requirePermission(data.PermissionTodosWrite)).Patch("/{id}", app.updateTodoHandler)
+		r.With(app.requirePermission(data.PermissionTodosWrite)).Post("/{id}/skip", app.skipOccurrenceHandler)
+		r.With(app.requirePermission(data.PermissionTodosWrite)).Post("/{id}/restore", app.restoreTodoHandler)
+		r.With(app.requirePermission(data.PermissionTodosWrite)).Post("/{id}/archive", app.archiveTodoHandler)
+		r.With(app.requirePermission(data.PermissionTodosWrite)).Post("/{id}/unarchive", app.unarchiveTodoHandler)
+		r.With(app.requirePermission(data.PermissionTodosRead)).Get("/{id}/reminders", app.listRemindersHandler)
+		r.With(app.requirePermission(data.PermissionTodosWrite)).Post("/{id}/reminders", app.createReminderHandler)
+		r.With(app.requirePermission(data.PermissionTodosWrite)).Delete("/{id}/reminders/{reminderID}", app.deleteReminderHandler)
+		r.With(app.requirePermission(data.PermissionTodosWrite)).Delete("/{id}", app.deleteTodoHandler)
+		r.With(app.requirePermission(data.PermissionTodosWrite)).Post("/", app.createTodoHandler)
+	})
+
+	// tag routes group, tags are part of the todos as far as permissions go
+	r.Route("/tags", func(r chi.Router) {
+		r.Use(app.authenticate)
+		r.Use(app.requireActivatedUser)
+		r.With(app.requirePermission(data.PermissionTodosRead)).Get("/", app.listTagsHandler)
+		r.With(app.requirePermission(data.PermissionTodosWrite)).Post("/", app.createTagHandler)
+		r.With(app.requirePermission(data.PermissionTodosWrite)).Patch("/{id}", app.updateTagHandler)
+		r.With(app.requirePermission(data.PermissionTodosWrite)).Delete("/{id}", app.deleteTagHandler)
+	})
+
+	// project routes group, with the same permissions as the todos they hold
+	r.Route("/projects", func(r chi.Router) {
+		r.Use(app.authenticate)
+		r.Use(app.requireActivatedUser)
+		r.With(app.requirePermission(data.PermissionTodosRead)).Get("/", app.listProjectsHandler)
+		r.With(app.requirePermission(data.PermissionTodosRead)).Get("/{id}", app.getProjectHandler)
+		r.With(app.requirePermission(data.PermissionTodosRead)).Get("/{id}/todos", app.listProjectTodosHandler)
+		r.With(app.requirePermission(data.PermissionTodosWrite)).Post("/", app.createProjectHandler)
+		r.With(app.requirePermission(data.PermissionTodosWrite)).Patch("/{id}", app.updateProjectHandler)
+		r.With(app.requirePermission(data.PermissionTodosWrite)).Delete("/{id}", app.deleteProjectHandler)
 	})
 
 	// user route group
 	r.Group(func(r chi.Router) {
 		r.Post("/users", app.createUserHandler)
+		r.Put("/users/activated", app.activateUserHandler)
+		r.Put("/users/password", app.updateUserPasswordHandler)
+		r.Put("/users/email", app.confirmEmailChangeHandler)
 		// r.Get("/", app.createUserHandler)
 	})
 
+	// profile of the logged in user
+	r.Group(func(r chi.Router) {
+		r.Use(app.authenticate)
+		r.Use(app.requireFullAccess)
+		r.Get("/users/me", app.showCurrentUserHandler)
+		r.Patch("/users/me", app.updateCurrentUserHandler)
+		r.Put("/users/me/password", app.changePasswordHandler)
+		r.Put("/users/me/email", app.requestEmailChangeHandler)
+		r.Delete("/users/me", app.deleteCurrentUserHandler)
+		r.Delete("/users/me/deletion", app.cancelAccountDeletionHandler)
+		r.Post("/users/me/export", app.requestExportHandler)
+		r.Get("/users/me/export", app.downloadExportHandler)
+	})
+
 	// Authorization route group
 	r.Group(func(r chi.Router) {
 		r.Post("/auth/tokens", app.createTokenHandler)
+		r.Post("/auth/tokens/refresh", app.refreshTokenHandler)
+		r.Post("/auth/tokens/mfa", app.createMFATokenHandler)
+		r.Post("/auth/password-reset", app.createPasswordResetTokenHandler)
+		r.Get("/auth/oidc/{provider}", app.oidcLoginHandler)
+		r.Get("/auth/oidc/{provider}/callback", app.oidcCallbackHandler)
+	})
+
+	// session and API key management, for any authenticated user
+	r.Group(func(r chi.Router) {
+		r.Use(app.authenticate)
+		r.Use(app.requireFullAccess)
+		r.Delete("/auth/tokens", app.deleteTokenHandler)
+		r.Delete("/auth/tokens/all", app.deleteAllTokensHandler)
+		r.Get("/auth/sessions", app.listSessionsHandler)
+		r.Get("/auth/api-keys", app.listPersonalAccessTokensHandler)
+		r.Post("/auth/api-keys", app.createPersonalAccessTokenHandler)
+		r.Delete("/auth/api-keys/{id}", app.deletePersonalAccessTokenHandler)
+		r.Post("/auth/totp", app.enrollTOTPHandler)
+		r.Post("/auth/totp/confirm", app.confirmTOTPHandler)
+		r.Delete("/auth/totp", app.disableTOTPHandler)
+	})
+
+	// OAuth endpoints called by third-party clients, which authenticate themselves
+	r.Group(func(r chi.Router) {
+		r.Post("/oauth/token", app.oauthTokenHandler)
+		r.Post("/oauth/introspect", app.introspectHandler)
+		r.Post("/oauth/revoke", app.revokeHandler)
+	})
+
+	// OAuth client registration and consent, for users logged in with a session
+	r.Group(func(r chi.Router) {
+		r.Use(app.authenticate)
+		r.Use(app.requireActivatedUser)
+		r.Use(app.requireFullAccess)
+		r.Get("/oauth/authorize", app.authorizeInfoHandler)
+		r.Post("/oauth/authorize", app.authorizeHandler)
+		r.Get("/oauth/clients", app.listOAuthClientsHandler)
+		r.Post("/oauth/clients", app.createOAuthClientHandler)
+		r.Delete("/oauth/clients/{id}", app.deleteOAuthClientHandler)
+	})
+
+	// admin route group, scoped tokens are never allowed here
+	r.Route("/v1/admin", func(r chi.Router) {
+		r.Use(app.authenticate)
+		r.Use(app.requireActivatedUser)
+		r.Use(app.requireFullAccess)
+		r.Use(app.requirePermission(data.PermissionUsersAdmin))
+		r.Get("/users", app.adminListUsersHandler)
+		r.Get("/users/{id}", app.adminGetUserHandler)
+		r.Delete("/users/{id}", app.adminDeleteUserHandler)
+		r.Put("/users/{id}/activation", app.adminUpdateActivationHandler)
+		r.Post("/users/{id}/logout", app.adminLogoutUserHandler)
+		r.Post("/users/{id}/impersonate", app.adminImpersonateHandler)
+		r.Post("/users/{id}/roles", app.adminGrantRoleHandler)
+		r.Get("/audit", app.adminListAuditHandler)
 	})
 
 	return app.recoverPanic(r)
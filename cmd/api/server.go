@@ -57,14 +57,37 @@ func (app *application) serve() error {
 		// Shutdown() will return nil if the graceful shutdown was successful, or an
 		// error (which may happen because of a problem closing the listeners, or
 		// because the shutdown didn't complete before the 5-second context deadline is
-		// hit). We relay this return value to the shutdownError channel.
-		shutdownError <- srv.Shutdown(ctx)
+		// hit). We only relay the error to the shutdownError channel if there is one.
+		err := srv.Shutdown(ctx)
+
+		// no more requests are coming in, so tell the periodic jobs to stop as well
+		close(app.done)
+
+		if err != nil {
+			shutdownError <- err
+			return
+		}
+
+		// Log a message to say that we're waiting for any background go-routines to
+		// complete their tasks.
+		app.logger.PrintInfo("completing background tasks", map[string]string{
+			"addr": srv.Addr,
+		})
+
+		// Call Wait() to block until our WaitGroup counter is zero --- essentially
+		// blocking until the background go-routines have finished. Then we return nil on
+		// the shutdownError channel, to indicate that the shutdown completed without
+		// any issues.
+		app.wg.Wait()
+		shutdownError <- nil
 
 	}()
 
 	// Again, we use the PrintInfo() method to write a "starting server" message at the
 	// INFO level. But this time we pass a map containing additional properties (the
 	// operating environment and server address) as the final parameter.
+	app.startBackgroundJobs()
+
 	app.logger.PrintInfo("starting server", map[string]string{
 		"addr": srv.Addr,
 		"env":  Configs().env,
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ridwanulhoquejr/todo-app/internal/data"
+	"github.com/ridwanulhoquejr/todo-app/internal/validator"
+)
+
+// checkTodoParent checks that a todo (todoID is 0 for a new one) can become a subtask
+// of parentID; a nil parent makes it a top-level todo.
+func (app *application) checkTodoParent(v *validator.Validator, userID, todoID int64, parentID *int64) error {
+
+	if parentID == nil {
+		return nil
+	}
+
+	err := app.models.Todo.CheckParent(userID, todoID, *parentID)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, data.ErrRecordNotFound):
+		v.AddError("parent_id", "must be one of your todos")
+	case errors.Is(err, data.ErrTodoCycle):
+		v.AddError("parent_id", "must not be the todo itself or one of its subtasks")
+	case errors.Is(err, data.ErrTodoTooDeep):
+		v.AddError("parent_id", fmt.Sprintf("must not nest subtasks more than %d levels deep", data.MaxTodoDepth))
+	default:
+		return err
+	}
+	return nil
+}
+
+// checkOpenSubtasks is called when a todo gets completed. Unless its subtasks are to
+// be completed along with it (subtasks=complete), it's blocked while any of them are
+// still open.
+func (app *application) checkOpenSubtasks(v *validator.Validator, userID int64, todo *data.Todo, mode string) error {
+
+	if mode == "complete" {
+		return nil
+	}
+
+	open, err := app.models.Todo.CountOpenSubtasks(todo.ID, userID)
+	if err != nil {
+		return err
+	}
+
+	v.Check(open == 0, "completed", fmt.Sprintf("has %d open subtasks; complete them first, or pass subtasks=complete", open))
+	return nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ridwanulhoquejr/todo-app/internal/data"
+	"github.com/ridwanulhoquejr/todo-app/internal/validator"
+)
+
+// tagsFromNames turns the tag names of a todo payload into tags, which the model
+// creates if the user doesn't have them yet.
+func tagsFromNames(names []string) []*data.Tag {
+	tags := make([]*data.Tag, len(names))
+	for i, name := range names {
+		tags[i] = &data.Tag{Name: strings.TrimSpace(name)}
+	}
+	return tags
+}
+
+// uniqueFold drops repeated values from the list, ignoring case as tag names do.
+func uniqueFold(values []string) []string {
+	var unique []string
+	for _, value := range values {
+		seen := false
+		for _, u := range unique {
+			if strings.EqualFold(u, value) {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
+
+func (app *application) listTagsHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	tags, err := app.models.Tag.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"tags": tags}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) createTagHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	var payload struct {
+		Name  string `json:"name"`
+		Color string `json:"color"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	tag := &data.Tag{
+		UserID: user.ID,
+		Name:   strings.TrimSpace(payload.Name),
+		Color:  payload.Color,
+	}
+	if tag.Color == "" {
+		tag.Color = data.DefaultColor
+	}
+
+	v := validator.New()
+
+	if data.ValidateTag(v, tag); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Tag.Insert(tag)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateTag):
+			v.AddError("name", "a tag with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/tags/%d", tag.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, tag, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) updateTagHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if id <= 0 {
+		app.badRequestResponse(w, r, errInvalidPathParam)
+		return
+	}
+
+	tag, err := app.models.Tag.Get(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var payload struct {
+		Name  *string `json:"name"`
+		Color *string `json:"color"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if payload.Name != nil {
+		tag.Name = strings.TrimSpace(*payload.Name)
+	}
+	if payload.Color != nil {
+		tag.Color = *payload.Color
+	}
+
+	v := validator.New()
+
+	if data.ValidateTag(v, tag); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Tag.Update(tag)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateTag):
+			v.AddError("name", "a tag with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, tag, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// deleteTagHandler deletes a tag, which removes it from all of the user's todos.
+func (app *application) deleteTagHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if id <= 0 {
+		app.badRequestResponse(w, r, errInvalidPathParam)
+		return
+	}
+
+	err = app.models.Tag.Delete(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "tag successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/ridwanulhoquejr/todo-app/internal/data"
@@ -12,6 +13,45 @@ import (
 
 var errInvalidPathParam = errors.New("invalid id: path parameter must be greater than zero")
 
+// parseDueAt reads the due_at of a payload, which is an RFC 3339 timestamp. All-day
+// todos may give just the day (2006-01-02) instead; either way they are due at the
+// start of that day in loc. UserModel.Update keeps them there when the user's time
+// zone changes.
+func parseDueAt(value string, allDay bool, loc *time.Location) (time.Time, bool) {
+
+	if allDay {
+		if t, err := time.ParseInLocation("2006-01-02", value, loc); err == nil {
+			return t, true
+		}
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	if allDay {
+		return startOfDay(t, loc), true
+	}
+	return t, true
+}
+
+// startOfDay returns midnight of the day t falls on in loc.
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	y, m, d := t.In(loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, loc)
+}
+
+// userLocation returns the time zone of the authenticated user. Only the database has
+// an up to date copy of it, the context user may come from a self-contained token.
+func (app *application) userLocation(r *http.Request) (*time.Location, error) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		return nil, err
+	}
+	return user.Location(), nil
+}
+
 func (app *application) getQueries(
 	r *http.Request, v *validator.Validator) data.Queries {
 
@@ -26,17 +66,116 @@ func (app *application) getQueries(
 	// get the Filters
 	q.Sorts.Sort = app.readString(qs, "sort", "-creation_time")
 	// add a Sortsafelist
-	q.Sorts.SafeList = []string{"title", "id", "creation_time", "-title", "-id", "-creation_time"}
+	// priority sorts by rank, so -priority puts the urgent todos first
+	q.Sorts.SafeList = []string{"title", "id", "creation_time", "due_at", "priority", "completed_at", "-title", "-id", "-creation_time", "-due_at", "-priority", "-completed_at"}
+
+	// filter=completed eq false and priority ge high, see data.FilterExpr
+	if s := qs.Get("filter"); s != "" {
+		expr, err := data.ParseFilter(s)
+		if err != nil {
+			v.AddError("filter", err.Error())
+		}
+		q.Filter = expr
+	}
+
+	// get time for range filters, by default the todos created in the last month
+	// unless the filter says otherwise
+	var oneMonthAgo, now time.Time
+	if !data.FilterUses(q.Filter, "creation_time") {
+		now = time.Now()
+		oneMonthAgo = now.AddDate(0, -1, 0)
+	}
+	q.Filters.StartDate = app.readTime(qs, "start_date", oneMonthAgo, v)
+	q.Filters.EndDate = app.readTime(qs, "end_date", now, v)
+
+	// completed=true or false, both if it's left out
+	if qs.Get("completed") != "" {
+		completed := app.readBoolean(qs, "completed", false, v)
+		q.Filters.Completed = &completed
+	}
+
+	// due date filters, these are days in the user's time zone
+	q.Filters.DueBefore = app.readTime(qs, "due_before", time.Time{}, v)
+	q.Filters.DueAfter = app.readTime(qs, "due_after", time.Time{}, v)
+	q.Filters.Overdue = app.readBoolean(qs, "overdue", false, v)
+	q.Filters.DueToday = app.readBoolean(qs, "due_today", false, v)
+
+	// completion date filters, also days in the user's time zone
+	q.Filters.CompletedBefore = app.readTime(qs, "completed_before", time.Time{}, v)
+	q.Filters.CompletedAfter = app.readTime(qs, "completed_after", time.Time{}, v)
+
+	// archived=true lists the archived todos instead, which are left out unless the
+	// filter says otherwise
+	if qs.Get("archived") != "" || !data.FilterUses(q.Filter, "archived") {
+		archived := app.readBoolean(qs, "archived", false, v)
+		q.Filters.Archived = &archived
+	}
+
+	// one or more priorities, e.g. priority=high,urgent
+	for _, name := range app.readCSV(qs, "priority", nil) {
+		p, ok := data.ParsePriority(strings.TrimSpace(name))
+		if !ok {
+			v.AddError("priority", "must be a list of none, low, medium, high or urgent")
+			continue
+		}
+		q.Filters.Priorities = append(q.Filters.Priorities, p)
+	}
+
+	// tags=work,urgent matches todos with any of the tags, or with all of them
+	// given tags_match=all
+	for _, name := range app.readCSV(qs, "tags", nil) {
+		if name = strings.TrimSpace(name); name != "" {
+			q.Filters.Tags = append(q.Filters.Tags, name)
+		}
+	}
+	q.Filters.Tags = uniqueFold(q.Filters.Tags)
+
+	match := app.readString(qs, "tags_match", "any")
+	v.Check(validator.In(match, "any", "all"), "tags_match", "must be any or all")
+	q.Filters.AllTags = match == "all"
 
-	// get time for range filters
-	now := time.Now()
-	oneMonthAgo := now.AddDate(0, -1, 0)
-	q.Filters.StartDate = app.readTime(qs, "start_date", oneMonthAgo)
-	q.Filters.EndDate = app.readTime(qs, "end_date", now)
+	// project_id=inbox lists the todos which aren't in any project
+	if qs.Get("project_id") == "inbox" {
+		q.Filters.Inbox = true
+	} else {
+		q.Filters.ProjectID = int64(app.readInt(qs, "project_id", 0, v))
+	}
 
 	return q
 }
 
+// readTodoParam looks up the todo of the {id} URL parameter among the todos of the
+// authenticated user. If it can't be found, an error response has already been sent
+// and false is returned.
+func (app *application) readTodoParam(w http.ResponseWriter, r *http.Request) (*data.Todo, bool) {
+
+	user := app.contextGetUser(r)
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil, false
+	}
+
+	if id <= 0 {
+		app.badRequestResponse(w, r, errInvalidPathParam)
+		return nil, false
+	}
+
+	todo, err := app.models.Todo.Get(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return todo, true
+}
+
 func (app *application) getTodoHandler(w http.ResponseWriter, r *http.Request) {
 
 	// extract the user info from r.Context()
@@ -53,7 +192,8 @@ func (app *application) getTodoHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	todo, err := app.models.Todo.Get(id, user.ID)
+	// the todo comes with all of its subtasks
+	todo, err := app.models.Todo.GetSubtree(id, user.ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -102,11 +242,21 @@ func (app *application) getAllTodoHandler(w http.ResponseWriter, r *http.Request
 }
 
 func (app *application) createTodoHandler(w http.ResponseWriter, r *http.Request) {
+
+	// the todo always belongs to the authenticated user
+	user := app.contextGetUser(r)
+
 	// 1. extract the payload
 	var payload struct {
-		Title      string `json:"title"`
-		Descripton string `json:"description"`
-		UserID     int64  `json:"user_id"`
+		Title      string           `json:"title"`
+		Descripton string           `json:"description"`
+		Priority   data.Priority    `json:"priority"`
+		DueAt      *string          `json:"due_at"`
+		AllDay     bool             `json:"all_day"`
+		Tags       []string         `json:"tags"`
+		ProjectID  *int64           `json:"project_id"`
+		ParentID   *int64           `json:"parent_id"`
+		Recurrence *data.Recurrence `json:"recurrence"`
 	}
 
 	err := app.readJSON(w, r, &payload)
@@ -119,12 +269,53 @@ func (app *application) createTodoHandler(w http.ResponseWriter, r *http.Request
 	todo := data.Todo{
 		Title:      payload.Title,
 		Descripton: payload.Descripton,
-		UserID:     payload.UserID, // this would be coming from token
+		UserID:     user.ID,
+		Priority:   payload.Priority,
+		AllDay:     payload.AllDay,
+		Tags:       tagsFromNames(payload.Tags),
+		ProjectID:  payload.ProjectID,
+		ParentID:   payload.ParentID,
+		Recurrence: payload.Recurrence,
 	}
 
 	// 3. validaton
 	v := validator.New()
 
+	if payload.DueAt != nil {
+		loc := time.UTC
+		if todo.AllDay {
+			loc, err = app.userLocation(r)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		dueAt, ok := parseDueAt(*payload.DueAt, todo.AllDay, loc)
+		v.Check(ok, "due_at", "must be an RFC 3339 timestamp, or a date for all-day todos")
+		todo.DueAt = &dueAt
+	}
+
+	err = app.checkTodoProject(v, user.ID, todo.ProjectID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.checkTodoParent(v, user.ID, 0, todo.ParentID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if todo.Recurrence != nil {
+		err = app.normalizeRecurrence(r, todo.Recurrence)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
 	if data.ValidateTodo(v, &todo); !v.Valid() {
 		app.logger.PrintError(err, map[string]string{"validation": "error returned from ValidateTodo"})
 		app.failedValidationResponse(w, r, v.Errors)
@@ -219,9 +410,16 @@ func (app *application) updateTodoHandler(w http.ResponseWriter, r *http.Request
 
 	// define a payload struct
 	var payload struct {
-		Title      *string `json:"title"`
-		Descripton *string `json:"description"`
-		Completed  *bool   `json:"completed"`
+		Title      *string                   `json:"title"`
+		Descripton *string                   `json:"description"`
+		Completed  *bool                     `json:"completed"`
+		Priority   *data.Priority            `json:"priority"`
+		DueAt      nullable[string]          `json:"due_at"`
+		AllDay     *bool                     `json:"all_day"`
+		Tags       *[]string                 `json:"tags"`
+		ProjectID  nullable[int64]           `json:"project_id"`
+		ParentID   nullable[int64]           `json:"parent_id"`
+		Recurrence nullable[data.Recurrence] `json:"recurrence"`
 	}
 
 	// extract the request payload to our defined payload
@@ -231,6 +429,21 @@ func (app *application) updateTodoHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// completing a todo with subtasks either completes them as well
+	// (subtasks=complete) or is blocked until they're done (subtasks=block)
+	v := validator.New()
+	subtasks := app.readString(r.URL.Query(), "subtasks", "block")
+	v.Check(validator.In(subtasks, "block", "complete"), "subtasks", "must be block or complete")
+
+	completing := payload.Completed != nil && *payload.Completed && !todo.Completed
+	if completing {
+		err = app.checkOpenSubtasks(v, user.ID, todo, subtasks)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
 	// as our payload is a pointers, we can check if it is nil
 	if payload.Title != nil {
 		todo.Title = *payload.Title
@@ -241,11 +454,95 @@ func (app *application) updateTodoHandler(w http.ResponseWriter, r *http.Request
 	if payload.Completed != nil {
 		todo.Completed = *payload.Completed
 	}
+	if payload.Priority != nil {
+		todo.Priority = *payload.Priority
+	}
+	if payload.Tags != nil {
+		todo.Tags = tagsFromNames(*payload.Tags)
+	}
 
 	// validation
-	v := validator.New()
 	v.Check(todo.Title != "", "title", "must be provided")
 
+	// a null due_at removes the due date, and with it the all-day flag unless that's
+	// given as well
+	if payload.AllDay != nil {
+		todo.AllDay = *payload.AllDay
+	} else if payload.DueAt.Set && !payload.DueAt.Valid {
+		todo.AllDay = false
+	}
+
+	if payload.DueAt.Set || (payload.AllDay != nil && todo.AllDay) {
+		loc := time.UTC
+		if todo.AllDay {
+			loc, err = app.userLocation(r)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		switch {
+		case payload.DueAt.Set && !payload.DueAt.Valid:
+			todo.DueAt = nil
+		case payload.DueAt.Set:
+			dueAt, ok := parseDueAt(payload.DueAt.Value, todo.AllDay, loc)
+			v.Check(ok, "due_at", "must be an RFC 3339 timestamp, or a date for all-day todos")
+			todo.DueAt = &dueAt
+		case todo.DueAt != nil:
+			// the todo just became all-day, so it's due at the start of its day
+			dueAt := startOfDay(*todo.DueAt, loc)
+			todo.DueAt = &dueAt
+		}
+	}
+
+	// moves the todo to another project, or with null to the inbox
+	if payload.ProjectID.Set {
+		todo.ProjectID = nil
+		if payload.ProjectID.Valid {
+			todo.ProjectID = &payload.ProjectID.Value
+		}
+
+		err = app.checkTodoProject(v, user.ID, todo.ProjectID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// moves the todo under another one, or with null to the top level
+	if payload.ParentID.Set {
+		todo.ParentID = nil
+		if payload.ParentID.Valid {
+			todo.ParentID = &payload.ParentID.Value
+		}
+
+		err = app.checkTodoParent(v, user.ID, todo.ID, todo.ParentID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// a null recurrence ends the series
+	if payload.Recurrence.Set {
+		todo.Recurrence = nil
+		if payload.Recurrence.Valid {
+			todo.Recurrence = &payload.Recurrence.Value
+
+			err = app.normalizeRecurrence(r, todo.Recurrence)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+	}
+
+	data.ValidatePriority(v, todo.Priority)
+	data.ValidateDueDate(v, todo)
+	data.ValidateTodoTags(v, todo)
+	data.ValidateRecurrence(v, todo)
+
 	if !v.Valid() {
 		app.logger.PrintError(err, map[string]string{"todo-update": "error returned from update-todo validator"})
 		app.failedValidationResponse(w, r, v.Errors)
@@ -254,7 +551,14 @@ func (app *application) updateTodoHandler(w http.ResponseWriter, r *http.Request
 
 	// call the db method
 	// TODO: user_id should come from the AUTHENTICATIONS!
-	err = app.models.Todo.Update(user.ID, todo)
+	//
+	// completing a recurring todo creates the todo of its next occurrence
+	var next *data.Todo
+	if completing {
+		next, err = app.models.Todo.Complete(user.ID, todo, subtasks == "complete")
+	} else {
+		err = app.models.Todo.Update(user.ID, todo)
+	}
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -265,8 +569,16 @@ func (app *application) updateTodoHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// return back the responses
-	err = app.writeJSON(w, http.StatusOK, todo, nil)
+	// return back the responses, along with the next occurrence if one was created
+	var response any = todo
+	if next != nil {
+		response = struct {
+			*data.Todo
+			NextOccurrence *data.Todo `json:"next_occurrence"`
+		}{todo, next}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, response, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -6,18 +6,42 @@ import (
 	"time"
 
 	"github.com/ridwanulhoquejr/todo-app/internal/data"
+	"github.com/ridwanulhoquejr/todo-app/internal/jwt"
 	"github.com/ridwanulhoquejr/todo-app/internal/validator"
 )
 
-// func (app *application) delelteTokenHandler(w http.ResponseWriter, r *http.Request) {
+// Lifetimes of the tokens handed out at login. Access tokens are kept short, as the
+// client can always exchange its refresh token for a new pair.
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// getScopedTokenUser looks up a personal access, impersonation or OAuth access token,
+// and the user owning it.
+func (app *application) getScopedTokenUser(scope, token string) (*data.User, *data.Token, error) {
+
+	t, err := app.models.Token.Get(scope, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := app.models.User.Get(t.UserId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, t, nil
+}
 
-// }
 func (app *application) createTokenHandler(w http.ResponseWriter, r *http.Request) {
 
 	// extract the payload
 	var payload struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email        string `json:"email"`
+		Password     string `json:"password"`
+		TOTPCode     string `json:"totp_code"`
+		RecoveryCode string `json:"recovery_code"`
 	}
 
 	// process it with readJSON helper method
@@ -38,13 +62,20 @@ func (app *application) createTokenHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if !app.checkLoginLockout(w, r, payload.Email) {
+		return
+	}
+
 	// check with user table, if email is exist or not
 	user, err := app.models.User.GetByEmail(payload.Email)
 
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
+			// answer exactly like for a wrong password, and take as long to do it
+			data.DummyPasswordMatches(payload.Password)
+			app.recordLoginFailure(r, payload.Email)
+			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -61,18 +92,267 @@ func (app *application) createTokenHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	if !match {
+		app.recordLoginFailure(r, payload.Email)
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
 
-	// generate a token
-	token, err := app.models.Token.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	// with two-factor authentication enabled the password alone isn't enough
+	enabled, err := app.totpEnabled(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if enabled {
+		// no code was given, so hand out a pending token which the client can
+		// exchange together with the code at /auth/tokens/mfa
+		if payload.TOTPCode == "" && payload.RecoveryCode == "" {
+			app.mfaRequiredResponse(w, r, user)
+			return
+		}
+
+		ok, err := app.verifySecondFactor(user.ID, payload.TOTPCode, payload.RecoveryCode)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !ok {
+			app.recordLoginFailure(r, payload.Email)
+			app.invalidTOTPCodeResponse(w, r)
+			return
+		}
+	}
+
+	err = app.resetLoginFailures(payload.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// generate an access and refresh token pair
+	pair, err := app.newTokenPair(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, pair, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// refreshTokenHandler exchanges a refresh token for a new token pair. Every refresh
+// token can only be used once; presenting it a second time revokes the whole session.
+func (app *application) refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	var payload struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, payload.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	pair, err := app.rotateTokenPair(r, payload.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTokenReused):
+			app.logger.PrintInfo("refresh token reuse detected, session revoked", map[string]string{
+				"ip_address": clientIP(r),
+				"user_agent": r.UserAgent(),
+			})
+			app.invalidAuthenticationTokenResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, pair, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// deleteTokenHandler revokes the token used to authenticate the request, i.e. logout.
+// The refresh token of the same session is revoked along with it.
+func (app *application) deleteTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	token := app.contextGetToken(r)
+
+	// a signed access token can't be deleted, so it is put on the denylist until it
+	// expires, and its session is ended by deleting the refresh tokens
+	if jwt.LooksLikeJWT(token) {
+		claims, err := app.verifyAccessToken(token)
+		if err != nil {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		entry := &data.DenylistEntry{JTI: claims.ID, Expiry: time.Unix(claims.ExpiresAt, 0)}
+
+		err = app.models.Denylist.Insert(entry)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.denylist.add(entry)
+
+		err = app.models.Token.DeleteByFamily(claims.Family)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"message": "token successfully revoked"}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err := app.models.Token.DeleteFamily(data.ScopeAuthentication, token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "token successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// deleteAllTokensHandler revokes every authentication token of the user, logging
+// them out everywhere, including the current session.
+func (app *application) deleteAllTokensHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+		err := app.models.Token.DeleteAllForUser(scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err := app.revokeAccessTokensForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "all tokens successfully revoked"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+}
+
+// listSessionsHandler lists the active login sessions of the user.
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	family, err := app.currentFamily(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	sessions, err := app.models.Token.GetSessionsForUser(user.ID, family)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	// extract the payload
+	var payload struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// validate payload
+	v := validator.New()
+
+	if data.ValidateEmail(v, payload.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// look up the user with the given email address. The response is the same whether
+	// or not there is an activated account with it, so that it can't be used to find
+	// out who has an account.
+	user, err := app.models.User.GetByEmail(payload.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// only activated accounts are allowed to reset their password
+	if err == nil && user.Activated {
+
+		// generate a short-lived password reset token
+		token, err := app.models.Token.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// email the user with their password reset token
+		app.background(func() {
+
+			data := map[string]any{
+				"passwordResetToken": token.Plaintext,
+			}
+
+			err := app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"mailer": "error sending password reset email"})
+			}
+		})
+	}
+
+	env := envelope{"message": "if an activated account exists for this email address, an email will be sent to it containing password reset instructions"}
 
-	err = app.writeJSON(w, http.StatusCreated, token, nil)
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ridwanulhoquejr/todo-app/internal/data"
+	"github.com/ridwanulhoquejr/todo-app/internal/totp"
+	"github.com/ridwanulhoquejr/todo-app/internal/validator"
+)
+
+// mfaPendingTokenTTL is how long a user has to enter their TOTP code after having
+// given the right password.
+const mfaPendingTokenTTL = 5 * time.Minute
+
+// totpEnabled reports whether the user has to give a second factor to log in.
+func (app *application) totpEnabled(userID int64) (bool, error) {
+
+	t, err := app.models.TOTP.Get(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return t.Enabled, nil
+}
+
+// verifySecondFactor checks either a TOTP code or a recovery code of the user. A
+// valid code is used up, it will be rejected if presented again.
+func (app *application) verifySecondFactor(userID int64, totpCode, recoveryCode string) (bool, error) {
+
+	if recoveryCode != "" {
+		err := app.models.TOTP.UseRecoveryCode(userID, recoveryCode)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				return false, nil
+			default:
+				return false, err
+			}
+		}
+		return true, nil
+	}
+
+	t, err := app.models.TOTP.Get(userID)
+	if err != nil {
+		return false, err
+	}
+
+	secret, err := app.decryptTOTPSecret(t)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := totp.Validate(secret, totpCode, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	err = app.models.TOTP.UseStep(userID, step)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTOTPCodeReused):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// mfaRequiredResponse answers a login which still needs a second factor with a
+// pending token, which can be exchanged together with the code at /auth/tokens/mfa.
+func (app *application) mfaRequiredResponse(w http.ResponseWriter, r *http.Request, user *data.User) {
+
+	token, err := app.models.Token.New(user.ID, mfaPendingTokenTTL, data.ScopeMFAPending)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"mfa_required": true, "mfa_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) decryptTOTPSecret(t *data.TOTP) (string, error) {
+
+	if app.secrets == nil {
+		return "", errors.New("TOTP_ENCRYPTION_KEY is not set, unable to decrypt TOTP secret")
+	}
+
+	secret, err := app.secrets.Open(t.Secret)
+	if err != nil {
+		return "", err
+	}
+
+	return string(secret), nil
+}
+
+// enrollTOTPHandler generates a new TOTP secret for the user. Two-factor
+// authentication isn't enabled until the user confirms it with a code from their
+// authenticator app.
+func (app *application) enrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	if app.secrets == nil {
+		app.totpNotConfiguredResponse(w, r)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	encrypted, err := app.secrets.Seal([]byte(secret))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.TOTP.Enroll(user.ID, encrypted)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTOTPEnabled):
+			app.errorResponse(w, r, http.StatusConflict, "two-factor authentication is already enabled")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	res := envelope{
+		"secret":           secret,
+		"provisioning_uri": totp.URI(app.config.totp.issuer, user.Email, secret),
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, res, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// confirmTOTPHandler enables two-factor authentication and returns the recovery codes,
+// which are never shown again.
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	var payload struct {
+		TOTPCode string `json:"totp_code"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTOTPCode(v, payload.TOTPCode); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	t, err := app.models.TOTP.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.errorResponse(w, r, http.StatusConflict, "two-factor authentication has not been enrolled in")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if t.Enabled {
+		app.errorResponse(w, r, http.StatusConflict, "two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := app.decryptTOTPSecret(t)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	step, ok := totp.Validate(secret, payload.TOTPCode, time.Now())
+	if !ok {
+		app.invalidTOTPCodeResponse(w, r)
+		return
+	}
+
+	codes, err := data.GenerateRecoveryCodes()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.TOTP.Enable(user.ID, step, codes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// disableTOTPHandler turns two-factor authentication off. Both the password and a
+// second factor are required, a stolen session alone isn't enough.
+func (app *application) disableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var payload struct {
+		Password     string `json:"password"`
+		TOTPCode     string `json:"totp_code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidatePasswordPlaintext(v, payload.Password)
+	if payload.RecoveryCode == "" {
+		data.ValidateTOTPCode(v, payload.TOTPCode)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	match, err := user.Password.Matches(payload.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	enabled, err := app.totpEnabled(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !enabled {
+		app.errorResponse(w, r, http.StatusConflict, "two-factor authentication is not enabled")
+		return
+	}
+
+	ok, err := app.verifySecondFactor(user.ID, payload.TOTPCode, payload.RecoveryCode)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		app.invalidTOTPCodeResponse(w, r)
+		return
+	}
+
+	err = app.models.TOTP.Delete(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// createMFATokenHandler is the second step of a login with two-factor authentication:
+// it exchanges the mfa pending token and a TOTP or recovery code for a token pair.
+func (app *application) createMFATokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	var payload struct {
+		MFAToken     string `json:"mfa_token"`
+		TOTPCode     string `json:"totp_code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateTokenPlaintext(v, payload.MFAToken)
+	if payload.RecoveryCode == "" {
+		data.ValidateTOTPCode(v, payload.TOTPCode)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.User.GetByToken(data.ScopeMFAPending, payload.MFAToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// wrong codes count against the account like wrong passwords do, otherwise the
+	// six digits could be guessed with a single pending token
+	if !app.checkLoginLockout(w, r, user.Email) {
+		return
+	}
+
+	ok, err := app.verifySecondFactor(user.ID, payload.TOTPCode, payload.RecoveryCode)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		app.recordLoginFailure(r, user.Email)
+		app.invalidTOTPCodeResponse(w, r)
+		return
+	}
+
+	// the pending token is single use, whoever gets to delete it wins the race
+	err = app.models.Token.Delete(data.ScopeMFAPending, payload.MFAToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.resetLoginFailures(user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	pair, err := app.newTokenPair(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, pair, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
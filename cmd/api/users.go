@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/ridwanulhoquejr/todo-app/internal/data"
 	"github.com/ridwanulhoquejr/todo-app/internal/validator"
@@ -18,6 +19,7 @@ func (app *application) createUserHandler(w http.ResponseWriter, r *http.Request
 		Name     string `json:"name"`
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		TimeZone string `json:"time_zone"`
 	}
 
 	// use our readJSON method for converting the JSON
@@ -38,6 +40,7 @@ func (app *application) createUserHandler(w http.ResponseWriter, r *http.Request
 		Name:      payload.Name,
 		Email:     payload.Email,
 		Activated: false,
+		TimeZone:  payload.TimeZone,
 	}
 	// process the password, convert the plain-password to hash
 	err = user.Password.Set(payload.Password)
@@ -62,8 +65,8 @@ func (app *application) createUserHandler(w http.ResponseWriter, r *http.Request
 		"validation": "success",
 	})
 
-	// 4. perform the db transactions
-	err = app.models.User.Insert(&user)
+	// 4. perform the db transactions, every new user starts out as a member
+	err = app.models.User.Insert(&user, data.RoleMember)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
@@ -76,8 +79,463 @@ func (app *application) createUserHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// 5. return the response!
-	err = app.writeJSON(w, http.StatusCreated, user, nil)
+	// 5. generate an activation token for the newly created user
+	token, err := app.models.Token.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// 6. send the welcome email with the activation token in the background,
+	// so the client doesn't have to wait on the mail server
+	app.background(func() {
+
+		data := map[string]any{
+			"activationToken": token.Plaintext,
+			"name":            user.Name,
+			"userID":          user.ID,
+		}
+
+		err := app.mailer.Send(user.Email, "user_welcome.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"mailer": "error sending welcome email"})
+		}
+	})
+
+	// 7. return the response!
+	// 202 Accepted, as the activation email is still on its way
+	err = app.writeJSON(w, http.StatusAccepted, user, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
+
+	// extract the plaintext activation token from the payload
+	var payload struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// validate the plaintext token provided by the client
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, payload.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// retrieve the details of the user associated with the token. If no matching
+	// record is found, then we let the client know that the token they provided
+	// is not valid.
+	user, err := app.models.User.GetByToken(data.ScopeActivation, payload.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired activation token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// update the user's activation status
+	user.Activated = true
+
+	// save the updated user record in our database, checking for any edit
+	// conflicts in the same way that we did for our todo records
+	err = app.models.User.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// if everything went successfully, then we delete all activation tokens
+	// for the user
+	err = app.models.Token.DeleteAllForUser(data.ScopeActivation, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, user, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+
+	// extract the new password and the plaintext reset token
+	var payload struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidatePasswordPlaintext(v, payload.Password)
+	data.ValidateTokenPlaintext(v, payload.TokenPlaintext)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// retrieve the details of the user associated with the password reset token
+	user, err := app.models.User.GetByToken(data.ScopePasswordReset, payload.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// set the new password for the user
+	err = user.Password.Set(payload.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// save the new password and revoke all of the user's tokens in one go
+	err = app.models.User.ResetPassword(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.revokeAccessTokensForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"message": "your password was successfully reset"}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// currentUser loads the authenticated user from the database. The user in the request
+// context may have been rebuilt from the claims of a signed access token, which
+// carry neither the password hash nor the version needed to update it.
+func (app *application) currentUser(r *http.Request) (*data.User, error) {
+	return app.models.User.Get(app.contextGetUser(r).ID)
+}
+
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, user, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// updateCurrentUserHandler updates the profile fields which don't need the password
+// to change: the name and the time zone.
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var payload struct {
+		Name     *string `json:"name"`
+		TimeZone *string `json:"time_zone"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if payload.Name != nil {
+		user.Name = *payload.Name
+	}
+
+	v := validator.New()
+
+	if payload.TimeZone != nil {
+		user.TimeZone = *payload.TimeZone
+		data.ValidateTimeZone(v, user.TimeZone)
+	}
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.User.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, user, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// changePasswordHandler sets a new password after checking the current one. Every
+// session of the user is logged out, and the caller gets a new token pair so that
+// they can carry on.
+func (app *application) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var payload struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(payload.CurrentPassword != "", "current_password", "must be provided")
+	data.ValidatePasswordPlaintext(v, payload.NewPassword)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	match, err := user.Password.Matches(payload.CurrentPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	err = user.Password.Set(payload.NewPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.User.ResetPassword(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.revokeAccessTokensForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	pair, err := app.newTokenPair(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, pair, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// requestEmailChangeHandler starts changing the email address of the user. The new
+// address only replaces the current one once it's confirmed with the token sent to
+// it, so a typo can't lock the user out of their account.
+func (app *application) requestEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var payload struct {
+		CurrentPassword string `json:"current_password"`
+		Email           string `json:"email"`
+	}
+
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(payload.CurrentPassword != "", "current_password", "must be provided")
+	data.ValidateEmail(v, payload.Email)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	match, err := user.Password.Matches(payload.CurrentPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	_, err = app.models.User.GetByEmail(payload.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email address already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.User.RequestEmailChange(user.ID, payload.Email, 24*time.Hour)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the token goes to the new address, proving that the user can read mail there
+	app.background(func() {
+
+		data := map[string]any{
+			"emailChangeToken": token.Plaintext,
+			"name":             user.Name,
+		}
+
+		err := app.mailer.Send(payload.Email, "token_email_change.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"mailer": "error sending email change email"})
+		}
+	})
+
+	env := envelope{"message": "an email will be sent to the new address containing confirmation instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// confirmEmailChangeHandler completes an email change with the token sent to the new
+// address.
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+
+	var payload struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, payload.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.User.GetByToken(data.ScopeEmailChange, payload.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.User.ConfirmEmailChange(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, user, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -0,0 +1,232 @@
+// Command oidc-stub is a minimal OpenID provider for local development. It logs in
+// every authorization request straight away, as the user given by the login_hint
+// parameter or the -email flag, so the OIDC login of the API can be tried out
+// without a real identity provider.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ridwanulhoquejr/todo-app/internal/jwt"
+)
+
+type authorization struct {
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	email         string
+	expiry        time.Time
+}
+
+type stub struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	email        string
+	name         string
+	keys         *jwt.KeySet
+
+	mu    sync.Mutex
+	codes map[string]*authorization
+}
+
+func main() {
+
+	addr := flag.String("addr", "localhost:9999", "address to listen on")
+	issuer := flag.String("issuer", "http://localhost:9999", "issuer URL, must match the address the API reaches the stub on")
+	clientID := flag.String("client-id", "todo-app", "the only client allowed to log in")
+	clientSecret := flag.String("client-secret", "secret", "secret of the client")
+	email := flag.String("email", "stub-user@example.com", "email of the user logged in, unless a login_hint is given")
+	name := flag.String("name", "Stub User", "name of the user logged in")
+	flag.Parse()
+
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	key, err := jwt.NewRS256Key("stub", private)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	keys, err := jwt.NewKeySet("stub", key)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s := &stub{
+		issuer:       strings.TrimSuffix(*issuer, "/"),
+		clientID:     *clientID,
+		clientSecret: *clientSecret,
+		email:        *email,
+		name:         *name,
+		keys:         keys,
+		codes:        make(map[string]*authorization),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discoveryHandler)
+	mux.HandleFunc("/jwks", s.jwksHandler)
+	mux.HandleFunc("/authorize", s.authorizeHandler)
+	mux.HandleFunc("/token", s.tokenHandler)
+
+	log.Printf("stub OIDC issuer %s listening on %s", s.issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
+
+func (s *stub) discoveryHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                s.issuer,
+		"authorization_endpoint":                s.issuer + "/authorize",
+		"token_endpoint":                        s.issuer + "/token",
+		"jwks_uri":                              s.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{jwt.RS256},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (s *stub) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.keys.JWKS())
+}
+
+// authorizeHandler skips the login page and consent, and sends the user straight
+// back to the client with a code.
+func (s *stub) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+
+	qs := r.URL.Query()
+
+	if qs.Get("client_id") != s.clientID || qs.Get("response_type") != "code" {
+		http.Error(w, "unknown client or unsupported response type", http.StatusBadRequest)
+		return
+	}
+
+	if qs.Get("code_challenge_method") != "S256" || qs.Get("code_challenge") == "" {
+		http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+
+	redirect, err := url.Parse(qs.Get("redirect_uri"))
+	if err != nil || redirect.Scheme == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	email := qs.Get("login_hint")
+	if email == "" {
+		email = s.email
+	}
+
+	code := randomString()
+
+	s.mu.Lock()
+	s.codes[code] = &authorization{
+		clientID:      s.clientID,
+		redirectURI:   redirect.String(),
+		nonce:         qs.Get("nonce"),
+		codeChallenge: qs.Get("code_challenge"),
+		email:         email,
+		expiry:        time.Now().Add(time.Minute),
+	}
+	s.mu.Unlock()
+
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", qs.Get("state"))
+	redirect.RawQuery = params.Encode()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *stub) tokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	if clientID != s.clientID || clientSecret != s.clientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	s.mu.Lock()
+	auth, ok := s.codes[r.PostFormValue("code")]
+	delete(s.codes, r.PostFormValue("code"))
+	s.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+
+	if !ok || time.Now().After(auth.expiry) ||
+		auth.redirectURI != r.PostFormValue("redirect_uri") ||
+		auth.codeChallenge != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+
+	claims := map[string]any{
+		"iss":            s.issuer,
+		"sub":            "stub|" + auth.email,
+		"aud":            auth.clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          auth.nonce,
+		"email":          auth.email,
+		"email_verified": true,
+		"name":           s.name,
+	}
+
+	idToken, err := s.keys.Sign(claims)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,284 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Statuses of a data export.
+const (
+	ExportPending    = "pending"
+	ExportProcessing = "processing"
+	ExportReady      = "ready"
+	ExportFailed     = "failed"
+)
+
+// exportStuckAfter is how long an export may stay in processing before another worker
+// picks it up again, e.g. because the one working on it was killed.
+const exportStuckAfter = 10 * time.Minute
+
+// AccountDeletion is a deletion the user asked for, which is carried out once the
+// grace period is over unless they cancel it.
+type AccountDeletion struct {
+	UserID       int64     `json:"-"`
+	ScheduledFor time.Time `json:"scheduled_for"`
+	CreationTime time.Time `json:"creation_time"`
+}
+
+type AccountDeletionModel struct {
+	DB *sql.DB
+}
+
+// Schedule schedules the deletion of the user. Scheduling it again keeps the date of
+// the first request.
+func (m *AccountDeletionModel) Schedule(deletion *AccountDeletion) error {
+
+	query := `
+		INSERT INTO account_deletions (user_id, scheduled_for)
+			VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+			SET user_id = EXCLUDED.user_id
+		RETURNING scheduled_for, creation_time
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, deletion.UserID, deletion.ScheduledFor).Scan(
+		&deletion.ScheduledFor,
+		&deletion.CreationTime,
+	)
+}
+
+// Cancel removes the scheduled deletion of the user.
+func (m *AccountDeletionModel) Cancel(userID int64) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM account_deletions WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetDue returns the users whose grace period is over.
+func (m *AccountDeletionModel) GetDue() ([]int64, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT user_id FROM account_deletions WHERE scheduled_for <= $1`, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+
+	for rows.Next() {
+		var id int64
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// Export is an archive of everything we store about a user. It is generated in the
+// background, the user polls for it and downloads it once it's ready.
+type Export struct {
+	ID           int64      `json:"id"`
+	UserID       int64      `json:"-"`
+	Status       string     `json:"status"`
+	Archive      []byte     `json:"-"`
+	Error        string     `json:"error,omitempty"`
+	CreationTime time.Time  `json:"creation_time"`
+	CompletedAt  *time.Time `json:"completed_at"`
+	Expiry       *time.Time `json:"expiry"`
+}
+
+type ExportModel struct {
+	DB *sql.DB
+}
+
+func (m *ExportModel) Insert(export *Export) error {
+
+	query := `
+		INSERT INTO data_exports (user_id)
+			VALUES ($1)
+		RETURNING id, status, creation_time
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, export.UserID).Scan(&export.ID, &export.Status, &export.CreationTime)
+}
+
+// GetLatestForUser returns the most recent export of the user which hasn't expired,
+// without the archive itself unless withArchive is set.
+func (m *ExportModel) GetLatestForUser(userID int64, withArchive bool) (*Export, error) {
+
+	query := `
+		SELECT id, user_id, status, CASE WHEN $2 THEN archive END, error, creation_time, completed_at, expiry
+		FROM data_exports
+			WHERE user_id = $1 AND (expiry IS NULL OR expiry > $3)
+		ORDER BY id DESC
+		LIMIT 1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var export Export
+
+	err := m.DB.QueryRowContext(ctx, query, userID, withArchive, time.Now()).Scan(
+		&export.ID,
+		&export.UserID,
+		&export.Status,
+		&export.Archive,
+		&export.Error,
+		&export.CreationTime,
+		&export.CompletedAt,
+		&export.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &export, nil
+}
+
+// Claim marks the oldest pending export as being processed and returns it.
+// FOR UPDATE SKIP LOCKED lets several instances of the API poll for exports without
+// working on the same one. ErrRecordNotFound means there is nothing to do.
+func (m *ExportModel) Claim() (*Export, error) {
+
+	query := `
+		UPDATE data_exports
+			SET status = $1, started_at = now()
+			WHERE id = (
+				SELECT id FROM data_exports
+					WHERE status = $2 OR (status = $1 AND started_at < $3)
+				ORDER BY id
+				LIMIT 1
+				FOR UPDATE SKIP LOCKED
+			)
+		RETURNING id, user_id, status, creation_time
+	`
+	args := []any{ExportProcessing, ExportPending, time.Now().Add(-exportStuckAfter)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var export Export
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&export.ID,
+		&export.UserID,
+		&export.Status,
+		&export.CreationTime,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &export, nil
+}
+
+// Complete stores the archive of the export, which is kept until it expires.
+func (m *ExportModel) Complete(id int64, archive []byte, ttl time.Duration) error {
+
+	query := `
+		UPDATE data_exports
+			SET status = $2, archive = $3, completed_at = now(), expiry = $4
+			WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, ExportReady, archive, time.Now().Add(ttl))
+	return err
+}
+
+// Fail records that the export couldn't be generated.
+func (m *ExportModel) Fail(id int64, reason string) error {
+
+	query := `
+		UPDATE data_exports
+			SET status = $2, error = $3, completed_at = now()
+			WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, ExportFailed, reason)
+	return err
+}
+
+// DeleteExpired removes the exports which can no longer be downloaded.
+func (m *ExportModel) DeleteExpired() error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM data_exports WHERE expiry <= $1`, time.Now())
+	return err
+}
+
+// TodosJSON returns every todo of the user as a JSON array, with all of their columns.
+// It is meant for exports, which should contain everything we have.
+func (m *ExportModel) TodosJSON(userID int64) (json.RawMessage, error) {
+
+	query := `
+		SELECT COALESCE(json_agg(t ORDER BY t.id), '[]')
+		FROM todo t
+			WHERE t.user_id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var todos []byte
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&todos)
+	if err != nil {
+		return nil, err
+	}
+
+	return todos, nil
+}
@@ -0,0 +1,54 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// SetArchived archives a todo, or takes it out of the archive, along with its
+// subtasks.
+func (m *TodoModel) SetArchived(id, userID int64, archived bool) error {
+
+	query := subtreeQuery + `
+		UPDATE todo
+			SET archived = $3, version = version + 1
+			WHERE (id = $1 AND user_id = $2 OR id IN (SELECT id FROM subtree))
+				AND deleted_at IS NULL
+				AND archived <> $3
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, userID, archived)
+	return err
+}
+
+// ArchiveCompleted archives the top-level todos which were completed more than after
+// ago, along with their subtasks.
+func (m *TodoModel) ArchiveCompleted(after time.Duration) error {
+
+	query := `
+		WITH RECURSIVE subtree AS (
+			SELECT id FROM todo
+			WHERE parent_id IS NULL
+				AND completed
+				AND completed_at < $1
+				AND NOT archived
+				AND deleted_at IS NULL
+			UNION ALL
+			SELECT todo.id FROM todo INNER JOIN subtree ON todo.parent_id = subtree.id
+			WHERE todo.deleted_at IS NULL
+		)
+		UPDATE todo
+			SET archived = true, version = version + 1
+			WHERE id IN (SELECT id FROM subtree)
+				AND NOT archived
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now().Add(-after))
+	return err
+}
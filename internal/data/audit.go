@@ -0,0 +1,115 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Audit actions. Everything an admin does to another account is recorded, as is every
+// request made with an impersonation token.
+const (
+	AuditUserActivated       = "user.activated"
+	AuditUserDeactivated     = "user.deactivated"
+	AuditUserDeleted         = "user.deleted"
+	AuditUserLoggedOut       = "user.logged_out"
+	AuditUserRoleGranted     = "user.role_granted"
+	AuditImpersonationStart  = "impersonation.started"
+	AuditImpersonatedRequest = "impersonation.request"
+)
+
+type AuditEvent struct {
+	ID           int64             `json:"id"`
+	ActorID      int64             `json:"actor_id"`
+	Action       string            `json:"action"`
+	TargetUserID int64             `json:"target_user_id"`
+	Details      map[string]string `json:"details"`
+	IPAddress    string            `json:"ip_address"`
+	CreationTime time.Time         `json:"creation_time"`
+}
+
+type AuditModel struct {
+	DB *sql.DB
+}
+
+func (m *AuditModel) Insert(event *AuditEvent) error {
+
+	details, err := json.Marshal(event.Details)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO audit_log (actor_id, action, target_user_id, details, ip_address)
+			VALUES ($1, $2, NULLIF($3, 0), COALESCE(NULLIF($4, 'null')::jsonb, '{}'), $5)
+		RETURNING id, creation_time
+	`
+	args := []any{event.ActorID, event.Action, event.TargetUserID, string(details), event.IPAddress}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreationTime)
+}
+
+// GetAll returns a page of audit events, newest first. If targetUserID isn't zero
+// only the events concerning that user are returned.
+func (m *AuditModel) GetAll(targetUserID int64, p Pagination) ([]*AuditEvent, Metadata, error) {
+
+	query := `
+		SELECT
+			count(*) OVER(), id, COALESCE(actor_id, 0), action, COALESCE(target_user_id, 0),
+			details, ip_address, creation_time
+		FROM audit_log
+			WHERE (target_user_id = $1 OR $1 = 0)
+		ORDER BY creation_time DESC, id DESC
+		LIMIT $2 OFFSET $3
+	`
+	args := []any{targetUserID, p.limit(), p.offset()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	events := []*AuditEvent{}
+	totalRecords := 0
+
+	for rows.Next() {
+
+		var event AuditEvent
+		var details []byte
+
+		err := rows.Scan(
+			&totalRecords,
+			&event.ID,
+			&event.ActorID,
+			&event.Action,
+			&event.TargetUserID,
+			&details,
+			&event.IPAddress,
+			&event.CreationTime,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		err = json.Unmarshal(details, &event.Details)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		events = append(events, &event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, p.Page, p.PageSize)
+	return events, metadata, nil
+}
@@ -0,0 +1,90 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// DenylistEntry revokes self-contained access tokens before they expire. An entry
+// either names a single token by its JTI, or (with an empty JTI) every token of a
+// user issued before RevokedAt. Entries aren't tied to the user row, so the ones for
+// a deleted account last until Expiry like any other.
+type DenylistEntry struct {
+	JTI       string
+	UserID    int64
+	RevokedAt time.Time
+	Expiry    time.Time
+}
+
+type DenylistModel struct {
+	DB *sql.DB
+}
+
+// Insert adds an entry. RevokedAt is taken from the clock of the API rather than the
+// database, as it is compared with the issue time of tokens it signed. It's truncated
+// to the microseconds Postgres stores, so that the copy in memory matches the row.
+func (m *DenylistModel) Insert(entry *DenylistEntry) error {
+
+	entry.RevokedAt = time.Now().Truncate(time.Microsecond)
+
+	query := `
+		INSERT INTO token_denylist (jti, user_id, revoked_at, expiry)
+			VALUES (NULLIF($1, ''), NULLIF($2, 0), $3, $4)
+	`
+	args := []any{entry.JTI, entry.UserID, entry.RevokedAt, entry.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetAll returns every entry which hasn't expired yet. Once the tokens an entry
+// refers to have expired there is nothing left to deny, so the list stays small.
+func (m *DenylistModel) GetAll() ([]*DenylistEntry, error) {
+
+	query := `
+		SELECT COALESCE(jti, ''), COALESCE(user_id, 0), revoked_at, expiry
+		FROM token_denylist
+			WHERE expiry > $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*DenylistEntry{}
+
+	for rows.Next() {
+
+		var entry DenylistEntry
+		err := rows.Scan(&entry.JTI, &entry.UserID, &entry.RevokedAt, &entry.Expiry)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// DeleteExpired removes the entries which are no longer needed.
+func (m *DenylistModel) DeleteExpired() error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM token_denylist WHERE expiry <= $1`, time.Now())
+	return err
+}
@@ -0,0 +1,378 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Limits on filter expressions, so that a single request can't make us build an
+// arbitrarily large query.
+const (
+	maxFilterLength = 2000
+	maxFilterTerms  = 50
+	maxFilterValues = 100
+)
+
+// FilterExpr is a node of a parsed filter expression, such as
+//
+//	completed eq false and (priority ge high or tag in (work, "side project"))
+//
+// Expressions are combined with and, or and not (not binds tightest, or loosest) and
+// grouped with parentheses. Each comparison is a field, an operator and a value, or a
+// parenthesized list of values for in. Values are either quoted strings or bare words
+// such as numbers, dates, priority names, true, false and null.
+//
+// The operators are eq, ne, lt, le, gt, ge and in, plus contains, startswith,
+// endswith and matches (a full text search) for text. Dates and today compare calendar
+// days in the user's time zone, RFC 3339 times and now the exact time. The fields, and
+// the operators each of them allows, are in filterFields.
+type FilterExpr interface {
+	filterExpr()
+}
+
+// FilterAnd matches todos matching both sides.
+type FilterAnd struct {
+	Left, Right FilterExpr
+}
+
+// FilterOr matches todos matching either side.
+type FilterOr struct {
+	Left, Right FilterExpr
+}
+
+// FilterNot matches todos not matching Expr.
+type FilterNot struct {
+	Expr FilterExpr
+}
+
+// FilterComparison compares a field to one value, or to a list of them for in.
+type FilterComparison struct {
+	Field  string
+	Op     string
+	Values []FilterValue
+}
+
+// FilterValue is a value as it was written. A bare null is the null value, a quoted
+// "null" the string.
+type FilterValue struct {
+	Text   string
+	Quoted bool
+}
+
+func (*FilterAnd) filterExpr()        {}
+func (*FilterOr) filterExpr()         {}
+func (*FilterNot) filterExpr()        {}
+func (*FilterComparison) filterExpr() {}
+
+func (v FilterValue) isNull() bool {
+	return !v.Quoted && strings.EqualFold(v.Text, "null")
+}
+
+// allOf joins the expressions with and, skipping nil ones. It returns nil when there
+// is nothing to join.
+func allOf(exprs ...FilterExpr) FilterExpr {
+
+	var joined FilterExpr
+	for _, expr := range exprs {
+		switch {
+		case expr == nil:
+		case joined == nil:
+			joined = expr
+		default:
+			joined = &FilterAnd{Left: joined, Right: expr}
+		}
+	}
+	return joined
+}
+
+// FilterUses tells whether the expression compares the field anywhere.
+func FilterUses(expr FilterExpr, field string) bool {
+
+	switch e := expr.(type) {
+	case *FilterAnd:
+		return FilterUses(e.Left, field) || FilterUses(e.Right, field)
+	case *FilterOr:
+		return FilterUses(e.Left, field) || FilterUses(e.Right, field)
+	case *FilterNot:
+		return FilterUses(e.Expr, field)
+	case *FilterComparison:
+		return e.Field == field
+	default:
+		return false
+	}
+}
+
+// ParseFilter parses a filter expression, checking its fields, operators and values
+// against the fields which can be filtered on.
+func ParseFilter(input string) (FilterExpr, error) {
+
+	if len(input) > maxFilterLength {
+		return nil, fmt.Errorf("must not be more than %d characters long", maxFilterLength)
+	}
+
+	tokens, err := lexFilter(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{tokens: tokens}
+
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %s", tok)
+	}
+	return expr, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type filterToken struct {
+	kind tokenKind
+	text string
+}
+
+func (t filterToken) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of filter"
+	case tokenString:
+		return fmt.Sprintf("string %q", t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+// is tells whether the token is the given keyword, ignoring case.
+func (t filterToken) is(keyword string) bool {
+	return t.kind == tokenWord && strings.EqualFold(t.text, keyword)
+}
+
+// lexFilter splits the input into tokens. Words run until whitespace, a parenthesis,
+// a comma or a quote; strings are double quoted, with \" and \\ as the only escapes.
+func lexFilter(input string) ([]filterToken, error) {
+
+	var tokens []filterToken
+	runes := []rune(input)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '(':
+			tokens = append(tokens, filterToken{tokenLParen, "("})
+			i++
+
+		case r == ')':
+			tokens = append(tokens, filterToken{tokenRParen, ")"})
+			i++
+
+		case r == ',':
+			tokens = append(tokens, filterToken{tokenComma, ","})
+			i++
+
+		case r == '"':
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(runes) {
+					return nil, fmt.Errorf("unterminated string")
+				}
+				if runes[i] == '"' {
+					i++
+					break
+				}
+				if runes[i] == '\\' {
+					if i+1 >= len(runes) || (runes[i+1] != '"' && runes[i+1] != '\\') {
+						return nil, fmt.Errorf(`invalid escape in string, only \" and \\ are allowed`)
+					}
+					i++
+				}
+				b.WriteRune(runes[i])
+				i++
+			}
+			tokens = append(tokens, filterToken{tokenString, b.String()})
+
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune(`(),"`, runes[i]) {
+				i++
+			}
+			tokens = append(tokens, filterToken{tokenWord, string(runes[start:i])})
+		}
+	}
+
+	return append(tokens, filterToken{kind: tokenEOF}), nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+	terms  int
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *filterParser) parseOr() (FilterExpr, error) {
+
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().is("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &FilterOr{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (FilterExpr, error) {
+
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().is("and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &FilterAnd{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseNot() (FilterExpr, error) {
+
+	if p.peek().is("not") {
+		p.next()
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &FilterNot{Expr: expr}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *filterParser) parsePrimary() (FilterExpr, error) {
+
+	tok := p.next()
+
+	switch tok.kind {
+	case tokenLParen:
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return nil, fmt.Errorf("expected \")\" but found %s", closing)
+		}
+		return expr, nil
+
+	case tokenWord:
+		return p.parseComparison(strings.ToLower(tok.text))
+
+	default:
+		return nil, fmt.Errorf("expected a field name but found %s", tok)
+	}
+}
+
+func (p *filterParser) parseComparison(field string) (FilterExpr, error) {
+
+	p.terms++
+	if p.terms > maxFilterTerms {
+		return nil, fmt.Errorf("must not have more than %d comparisons", maxFilterTerms)
+	}
+
+	op := p.next()
+	if op.kind != tokenWord {
+		return nil, fmt.Errorf("expected an operator after %q but found %s", field, op)
+	}
+
+	c := &FilterComparison{Field: field, Op: strings.ToLower(op.text)}
+
+	if c.Op == "in" {
+		if open := p.next(); open.kind != tokenLParen {
+			return nil, fmt.Errorf("expected \"(\" after in but found %s", open)
+		}
+		for {
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			c.Values = append(c.Values, value)
+
+			if len(c.Values) > maxFilterValues {
+				return nil, fmt.Errorf("must not have more than %d values for in", maxFilterValues)
+			}
+
+			tok := p.next()
+			if tok.kind == tokenRParen {
+				break
+			}
+			if tok.kind != tokenComma {
+				return nil, fmt.Errorf("expected \",\" or \")\" but found %s", tok)
+			}
+		}
+	} else {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		c.Values = []FilterValue{value}
+	}
+
+	err := checkComparison(c)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (p *filterParser) parseValue() (FilterValue, error) {
+
+	tok := p.next()
+
+	switch tok.kind {
+	case tokenString:
+		return FilterValue{Text: tok.text, Quoted: true}, nil
+	case tokenWord:
+		return FilterValue{Text: tok.text}, nil
+	default:
+		return FilterValue{}, fmt.Errorf("expected a value but found %s", tok)
+	}
+}
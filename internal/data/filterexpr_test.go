@@ -0,0 +1,261 @@
+package data
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+
+	cmp := func(field, op string, values ...FilterValue) *FilterComparison {
+		return &FilterComparison{Field: field, Op: op, Values: values}
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		want  FilterExpr
+	}{
+		{
+			name:  "comparison",
+			input: "completed eq false",
+			want:  cmp("completed", "eq", bare("false")),
+		},
+		{
+			name:  "keywords and fields ignore case",
+			input: "Completed EQ false AND NOT Archived eq true",
+			want: &FilterAnd{
+				Left:  cmp("completed", "eq", bare("false")),
+				Right: &FilterNot{Expr: cmp("archived", "eq", bare("true"))},
+			},
+		},
+		{
+			name:  "and binds tighter than or",
+			input: "id eq 1 or id eq 2 and id eq 3",
+			want: &FilterOr{
+				Left: cmp("id", "eq", bare("1")),
+				Right: &FilterAnd{
+					Left:  cmp("id", "eq", bare("2")),
+					Right: cmp("id", "eq", bare("3")),
+				},
+			},
+		},
+		{
+			name:  "not binds tightest",
+			input: "not id eq 1 and id eq 2",
+			want: &FilterAnd{
+				Left:  &FilterNot{Expr: cmp("id", "eq", bare("1"))},
+				Right: cmp("id", "eq", bare("2")),
+			},
+		},
+		{
+			name:  "parentheses",
+			input: "(id eq 1 or id eq 2) and id eq 3",
+			want: &FilterAnd{
+				Left: &FilterOr{
+					Left:  cmp("id", "eq", bare("1")),
+					Right: cmp("id", "eq", bare("2")),
+				},
+				Right: cmp("id", "eq", bare("3")),
+			},
+		},
+		{
+			name:  "and is left associative",
+			input: "id eq 1 and id eq 2 and id eq 3",
+			want: &FilterAnd{
+				Left: &FilterAnd{
+					Left:  cmp("id", "eq", bare("1")),
+					Right: cmp("id", "eq", bare("2")),
+				},
+				Right: cmp("id", "eq", bare("3")),
+			},
+		},
+		{
+			name:  "in list",
+			input: `tag in (work, "side project")`,
+			want:  cmp("tag", "in", bare("work"), quoted("side project")),
+		},
+		{
+			name:  "string escapes",
+			input: `title eq "say \"hi\" \\ bye"`,
+			want:  cmp("title", "eq", quoted(`say "hi" \ bye`)),
+		},
+		{
+			name:  "quoted null is a string",
+			input: `title eq "null"`,
+			want:  cmp("title", "eq", quoted("null")),
+		},
+		{
+			name:  "bare null",
+			input: "due_at eq null",
+			want:  cmp("due_at", "eq", bare("null")),
+		},
+		{
+			name:  "unicode",
+			input: `title contains "café" and tag eq naïve`,
+			want: &FilterAnd{
+				Left:  cmp("title", "contains", quoted("café")),
+				Right: cmp("tag", "eq", bare("naïve")),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFilter(tt.input)
+			if err != nil {
+				t.Fatalf("ParseFilter(%q) returned error: %v", tt.input, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseFilter(%q) = %#v, want %#v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+
+	manyTerms := strings.Repeat("id eq 1 and ", maxFilterTerms) + "id eq 1"
+	manyValues := "id in (" + strings.Repeat("1, ", maxFilterValues) + "1)"
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		// syntax
+		{"empty", "", "expected a field name but found end of filter"},
+		{"missing operator", "completed", `expected an operator after "completed" but found end of filter`},
+		{"missing value", "completed eq", "expected a value but found end of filter"},
+		{"value is a parenthesis", "completed eq (", `expected a value but found "("`},
+		{"trailing input", "completed eq false true", `unexpected "true"`},
+		{"dangling and", "completed eq false and", "expected a field name but found end of filter"},
+		{"unclosed parenthesis", "(completed eq false", `expected ")" but found end of filter`},
+		{"stray closing parenthesis", "completed eq false)", `unexpected ")"`},
+		{"field is a string", `"title" eq x`, `expected a field name but found string "title"`},
+		{"in without list", "id in 1", `expected "(" after in but found "1"`},
+		{"in list without comma", "id in (1 2)", `expected "," or ")" but found "2"`},
+		{"unclosed in list", "id in (1, 2", `expected "," or ")" but found end of filter`},
+		{"empty in list", "id in ()", `expected a value but found ")"`},
+		{"unterminated string", `title eq "abc`, "unterminated string"},
+		{"invalid escape", `title eq "a\nb"`, `invalid escape in string`},
+
+		// fields, operators and values
+		{"unknown field", "owner eq 1", `unknown field "owner"`},
+		{"unknown operator", "title like x", `"like" can't be used with title`},
+		{"ordering a bool", "completed gt false", `"gt" can't be used with completed`},
+		{"contains on a number", "id contains 1", `"contains" can't be used with id`},
+		{"in on a time", "due_at in (today)", `"in" can't be used with due_at`},
+		{"lt on a tag", "tag lt work", `"lt" can't be used with tag`},
+		{"not a bool", "completed eq yes", `completed: "yes" is not true or false`},
+		{"quoted bool", `completed eq "true"`, `completed: "true" is not true or false`},
+		{"not a number", "id eq one", `id: "one" is not a whole number`},
+		{"not a number in a list", "id in (1, x)", `id: "x" is not a whole number`},
+		{"not a priority", "priority ge critical", `priority: "critical" is not one of none, low, medium, high or urgent`},
+		{"not a time", "due_at lt tomorrow", `due_at: "tomorrow" is not a date, an RFC 3339 time, today or now`},
+		{"not a date", "due_at lt 2024-02-30", `due_at: "2024-02-30" is not a date`},
+
+		// null
+		{"null for a field which can't be null", "title eq null", "title can't be compared to null with eq"},
+		{"null ordered", "due_at lt null", "due_at can't be compared to null with lt"},
+		{"null in a list", "project_id in (1, null)", "project_id can't be compared to null with in"},
+
+		// limits
+		{"too long", "title eq \"" + strings.Repeat("a", maxFilterLength) + "\"", "must not be more than 2000 characters long"},
+		{"too many comparisons", manyTerms, "must not have more than 50 comparisons"},
+		{"too many values", manyValues, "must not have more than 100 values for in"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFilter(tt.input)
+			if err == nil {
+				t.Fatalf("ParseFilter(%q) = %#v, want error containing %q", tt.input, got, tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParseFilter(%q) returned error %q, want it to contain %q", tt.input, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilterAccepts(t *testing.T) {
+
+	inputs := []string{
+		"completed ne true",
+		"due_at eq null",
+		"due_at ne NULL",
+		"project_id eq null",
+		"parent_id ne null",
+		"completed_at eq null",
+		"priority in (high, URGENT)",
+		"priority lt medium",
+		"due_at le today",
+		"due_at gt now",
+		"due_at ge 2024-02-29",
+		"creation_time lt 2024-03-01T10:00:00+01:00",
+		"title startswith abc",
+		"description endswith \"x y\"",
+		"title matches \"quarterly report\"",
+		"title in (a, \"b c\")",
+		"tag ne work",
+		"overdue eq true",
+		"due_today eq false",
+		"recurring eq true",
+		"all_day eq true",
+		"id ge -1",
+		strings.Repeat("id eq 1 and ", maxFilterTerms-1) + "id eq 1",
+		"id in (" + strings.Repeat("1, ", maxFilterValues-1) + "1)",
+	}
+
+	for _, input := range inputs {
+		if _, err := ParseFilter(input); err != nil {
+			t.Errorf("ParseFilter(%q) returned error: %v", input, err)
+		}
+	}
+}
+
+func TestFilterUses(t *testing.T) {
+
+	expr, err := ParseFilter("completed eq false and (priority ge high or not archived eq true)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for field, want := range map[string]bool{
+		"completed":     true,
+		"priority":      true,
+		"archived":      true,
+		"creation_time": false,
+	} {
+		if got := FilterUses(expr, field); got != want {
+			t.Errorf("FilterUses(%s) = %v, want %v", field, got, want)
+		}
+	}
+
+	if FilterUses(nil, "completed") {
+		t.Error("FilterUses(nil) = true, want false")
+	}
+}
+
+func TestAllOf(t *testing.T) {
+
+	a := compare("id", "eq", bare("1"))
+	b := compare("id", "eq", bare("2"))
+
+	if got := allOf(); got != nil {
+		t.Errorf("allOf() = %#v, want nil", got)
+	}
+	if got := allOf(nil, nil); got != nil {
+		t.Errorf("allOf(nil, nil) = %#v, want nil", got)
+	}
+	if got := allOf(nil, a, nil); got != a {
+		t.Errorf("allOf(nil, a, nil) = %#v, want a", got)
+	}
+
+	want := &FilterAnd{Left: a, Right: b}
+	if got := allOf(a, nil, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("allOf(a, nil, b) = %#v, want %#v", got, want)
+	}
+}
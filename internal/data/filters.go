@@ -2,6 +2,7 @@ package data
 
 import (
 	"math"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,11 +15,30 @@ type Pagination struct {
 	PageSize int `json:"page_size"`
 }
 
-// Filters holds filtering criteria
+// Filters holds filtering criteria. A nil Completed or Archived matches both. A zero
+// StartDate or EndDate means no bound. The due date filters are calendar days in the
+// user's time zone; a zero DueBefore or DueAfter means no bound. An empty Priorities
+// matches every priority. Todos match Tags if they have any of them, or all of them
+// when AllTags is set. A zero ProjectID matches todos in any project, Inbox only the
+// ones without a project. CompletedBefore and CompletedAfter are days in the user's
+// time zone like the due date filters.
 type Filters struct {
-	Completed bool      `json:"completed"`
-	StartDate time.Time `json:"start_date"`
-	EndDate   time.Time `json:"end_date"`
+	Completed  *bool      `json:"completed"`
+	StartDate  time.Time  `json:"start_date"`
+	EndDate    time.Time  `json:"end_date"`
+	DueBefore  time.Time  `json:"due_before"`
+	DueAfter   time.Time  `json:"due_after"`
+	Overdue    bool       `json:"overdue"`
+	DueToday   bool       `json:"due_today"`
+	Priorities []Priority `json:"priorities"`
+	Tags       []string   `json:"tags"`
+	AllTags    bool       `json:"all_tags"`
+	ProjectID  int64      `json:"project_id"`
+	Inbox      bool       `json:"inbox"`
+
+	CompletedBefore time.Time `json:"completed_before"`
+	CompletedAfter  time.Time `json:"completed_after"`
+	Archived        *bool     `json:"archived"`
 }
 
 // Search holds the search criteria
@@ -32,12 +52,14 @@ type Sorts struct {
 	SafeList []string `json:"safe_list"`
 }
 
-// Queries wraps all filtering, sorting, pagination, and search options
+// Queries wraps all filtering, sorting, pagination, and search options. Filter is a
+// parsed filter expression, which todos have to match on top of Filters and Search.
 type Queries struct {
 	Pagination Pagination `json:"pagination"`
 	Filters    Filters    `json:"filters"`
 	Search     Search     `json:"search"`
 	Sorts      Sorts      `json:"sorts"`
+	Filter     FilterExpr `json:"-"`
 }
 
 // Define a new Metadata struct for holding the pagination metadata.
@@ -68,10 +90,7 @@ func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 }
 
 func ValidateQueries(v *validator.Validator, q Queries) {
-	v.Check(q.Pagination.Page > 0, "page", "must be greater than zero")
-	v.Check(q.Pagination.Page <= 10_100_000, "page", "must be maximum of 10 million")
-	v.Check(q.Pagination.PageSize > 0, "page_size", "must be greater than zero")
-	v.Check(q.Pagination.PageSize <= 100, "page_size", "must be maximum of 100")
+	ValidatePagination(v, q.Pagination)
 
 	// chekc the sorts.safelist
 	v.Check(validator.In(q.Sorts.Sort, q.Sorts.SafeList...), "sort", "invalid sort value")
@@ -81,6 +100,15 @@ func ValidateQueries(v *validator.Validator, q Queries) {
 	// v.Check(q.Filters.StartDate.Before(time.Now().AddDate(-1, 0, -1)), "start_date", "start_date must be in between less than 1 year of current date")
 }
 
+// ValidatePagination checks the page and page_size values, for listings which have
+// no other query options.
+func ValidatePagination(v *validator.Validator, p Pagination) {
+	v.Check(p.Page > 0, "page", "must be greater than zero")
+	v.Check(p.Page <= 10_100_000, "page", "must be maximum of 10 million")
+	v.Check(p.PageSize > 0, "page_size", "must be greater than zero")
+	v.Check(p.PageSize <= 100, "page_size", "must be maximum of 100")
+}
+
 // Check that the client-provided Sort field matches one of the entries in our safelist
 // and if it does, extract the column name from the Sort field by stripping the leading
 // hyphen character (if one exists)
@@ -103,6 +131,90 @@ func (s Sorts) sortDirection() string {
 	return "ASC"
 }
 
+// expr returns all the criteria of the queries as one filter expression.
+func (q Queries) expr() FilterExpr {
+
+	f := q.Filters
+	var exprs []FilterExpr
+
+	if q.Search.Title != "" {
+		exprs = append(exprs, compare("title", "matches", quoted(q.Search.Title)))
+	}
+	if f.Completed != nil {
+		exprs = append(exprs, compare("completed", "eq", bare(strconv.FormatBool(*f.Completed))))
+	}
+	if f.Archived != nil {
+		exprs = append(exprs, compare("archived", "eq", bare(strconv.FormatBool(*f.Archived))))
+	}
+	if !f.StartDate.IsZero() {
+		exprs = append(exprs, compare("creation_time", "ge", bare(f.StartDate.Format(time.RFC3339Nano))))
+	}
+	if !f.EndDate.IsZero() {
+		exprs = append(exprs, compare("creation_time", "le", bare(f.EndDate.Format(time.RFC3339Nano))))
+	}
+	if !f.DueBefore.IsZero() {
+		exprs = append(exprs, compare("due_at", "lt", bare(f.DueBefore.Format("2006-01-02"))))
+	}
+	if !f.DueAfter.IsZero() {
+		exprs = append(exprs, compare("due_at", "gt", bare(f.DueAfter.Format("2006-01-02"))))
+	}
+	if f.Overdue {
+		exprs = append(exprs, compare("overdue", "eq", bare("true")))
+	}
+	if f.DueToday {
+		exprs = append(exprs, compare("due_today", "eq", bare("true")))
+	}
+	if !f.CompletedBefore.IsZero() {
+		exprs = append(exprs, compare("completed_at", "lt", bare(f.CompletedBefore.Format("2006-01-02"))))
+	}
+	if !f.CompletedAfter.IsZero() {
+		exprs = append(exprs, compare("completed_at", "gt", bare(f.CompletedAfter.Format("2006-01-02"))))
+	}
+
+	if len(f.Priorities) > 0 {
+		values := make([]FilterValue, len(f.Priorities))
+		for i, p := range f.Priorities {
+			values[i] = bare(p.String())
+		}
+		exprs = append(exprs, compare("priority", "in", values...))
+	}
+
+	if len(f.Tags) > 0 {
+		if f.AllTags {
+			for _, tag := range f.Tags {
+				exprs = append(exprs, compare("tag", "eq", quoted(tag)))
+			}
+		} else {
+			values := make([]FilterValue, len(f.Tags))
+			for i, tag := range f.Tags {
+				values[i] = quoted(tag)
+			}
+			exprs = append(exprs, compare("tag", "in", values...))
+		}
+	}
+
+	switch {
+	case f.Inbox:
+		exprs = append(exprs, compare("project_id", "eq", bare("null")))
+	case f.ProjectID != 0:
+		exprs = append(exprs, compare("project_id", "eq", bare(strconv.FormatInt(f.ProjectID, 10))))
+	}
+
+	return allOf(append(exprs, q.Filter)...)
+}
+
+func compare(field, op string, values ...FilterValue) FilterExpr {
+	return &FilterComparison{Field: field, Op: op, Values: values}
+}
+
+func bare(text string) FilterValue {
+	return FilterValue{Text: text}
+}
+
+func quoted(text string) FilterValue {
+	return FilterValue{Text: text, Quoted: true}
+}
+
 func (p Pagination) limit() int {
 	return p.PageSize
 }
@@ -0,0 +1,319 @@
+package data
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/ridwanulhoquejr/todo-app/internal/validator"
+)
+
+type fieldKind int
+
+const (
+	fieldText fieldKind = iota
+	fieldBool
+	fieldNumber
+	fieldPriority
+	fieldTime
+	fieldTag
+)
+
+// filterField is a field which can be filtered on. Its SQL may refer to the todo table
+// and to settings.time_zone, the time zone of the user.
+type filterField struct {
+	sql      string
+	kind     fieldKind
+	nullable bool
+}
+
+// filterFields is the whitelist of fields filter expressions can use; nothing else
+// from an expression ever ends up in the SQL, the values all go in as arguments.
+var filterFields = map[string]filterField{
+	"id":            {sql: "todo.id", kind: fieldNumber},
+	"title":         {sql: "todo.title", kind: fieldText},
+	"description":   {sql: "todo.description", kind: fieldText},
+	"completed":     {sql: "todo.completed", kind: fieldBool},
+	"completed_at":  {sql: "todo.completed_at", kind: fieldTime, nullable: true},
+	"archived":      {sql: "todo.archived", kind: fieldBool},
+	"priority":      {sql: "todo.priority", kind: fieldPriority},
+	"due_at":        {sql: "todo.due_at", kind: fieldTime, nullable: true},
+	"all_day":       {sql: "todo.all_day", kind: fieldBool},
+	"creation_time": {sql: "todo.creation_time", kind: fieldTime},
+	"project_id":    {sql: "todo.project_id", kind: fieldNumber, nullable: true},
+	"parent_id":     {sql: "todo.parent_id", kind: fieldNumber, nullable: true},
+	"tag":           {kind: fieldTag},
+	"recurring":     {sql: "(todo.rrule IS NOT NULL)", kind: fieldBool},
+	"overdue": {kind: fieldBool, sql: `(NOT todo.completed AND
+		CASE WHEN todo.all_day
+			THEN (todo.due_at AT TIME ZONE settings.time_zone)::date < (now() AT TIME ZONE settings.time_zone)::date
+			ELSE todo.due_at <= now()
+		END)`},
+	"due_today": {kind: fieldBool, sql: "((todo.due_at AT TIME ZONE settings.time_zone)::date = (now() AT TIME ZONE settings.time_zone)::date)"},
+}
+
+// filterOps are the operators each kind of field can be compared with.
+var filterOps = map[fieldKind][]string{
+	fieldText:     {"eq", "ne", "contains", "startswith", "endswith", "matches", "in"},
+	fieldBool:     {"eq", "ne"},
+	fieldNumber:   {"eq", "ne", "lt", "le", "gt", "ge", "in"},
+	fieldPriority: {"eq", "ne", "lt", "le", "gt", "ge", "in"},
+	fieldTime:     {"eq", "ne", "lt", "le", "gt", "ge"},
+	fieldTag:      {"eq", "ne", "in"},
+}
+
+var sqlOps = map[string]string{
+	"eq": "=",
+	"ne": "IS DISTINCT FROM",
+	"lt": "<",
+	"le": "<=",
+	"gt": ">",
+	"ge": ">=",
+}
+
+// checkComparison checks the field, operator and values of a comparison.
+func checkComparison(c *FilterComparison) error {
+
+	field, ok := filterFields[c.Field]
+	if !ok {
+		return fmt.Errorf("unknown field %q", c.Field)
+	}
+
+	if !validator.In(c.Op, filterOps[field.kind]...) {
+		return fmt.Errorf("%q can't be used with %s", c.Op, c.Field)
+	}
+
+	if len(c.Values) == 0 || (c.Op != "in" && len(c.Values) > 1) {
+		return fmt.Errorf("wrong number of values for %s", c.Field)
+	}
+
+	for _, value := range c.Values {
+		if value.isNull() {
+			if !field.nullable || (c.Op != "eq" && c.Op != "ne") {
+				return fmt.Errorf("%s can't be compared to null with %s", c.Field, c.Op)
+			}
+			continue
+		}
+
+		_, err := filterArg(field.kind, value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.Field, err)
+		}
+	}
+	return nil
+}
+
+// filterArg converts a value into a query argument for a field of the kind.
+func filterArg(kind fieldKind, value FilterValue) (any, error) {
+
+	switch kind {
+	case fieldBool:
+		b, err := strconv.ParseBool(strings.ToLower(value.Text))
+		if err != nil || value.Quoted {
+			return nil, fmt.Errorf("%q is not true or false", value.Text)
+		}
+		return b, nil
+
+	case fieldNumber:
+		n, err := strconv.ParseInt(value.Text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a whole number", value.Text)
+		}
+		return n, nil
+
+	case fieldPriority:
+		p, ok := ParsePriority(value.Text)
+		if !ok {
+			return nil, fmt.Errorf("%q is not one of none, low, medium, high or urgent", value.Text)
+		}
+		return int64(p), nil
+
+	case fieldTime:
+		switch strings.ToLower(value.Text) {
+		case "today", "now":
+			return nil, nil
+		}
+		if _, err := time.Parse("2006-01-02", value.Text); err == nil {
+			return value.Text, nil
+		}
+		t, err := time.Parse(time.RFC3339, value.Text)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a date, an RFC 3339 time, today or now", value.Text)
+		}
+		return t, nil
+
+	default:
+		return value.Text, nil
+	}
+}
+
+// filterCompiler turns filter expressions into SQL, collecting the values as query
+// arguments along the way. Arguments which are already in args keep their numbers.
+type filterCompiler struct {
+	args []any
+}
+
+// arg adds a query argument and returns its placeholder.
+func (c *filterCompiler) arg(value any) string {
+	c.args = append(c.args, value)
+	return fmt.Sprintf("$%d", len(c.args))
+}
+
+// compile returns the SQL condition of the expression; a nil expression matches every
+// todo.
+func (c *filterCompiler) compile(expr FilterExpr) (string, error) {
+
+	switch e := expr.(type) {
+	case nil:
+		return "true", nil
+
+	case *FilterAnd:
+		return c.join(e.Left, " AND ", e.Right)
+
+	case *FilterOr:
+		return c.join(e.Left, " OR ", e.Right)
+
+	case *FilterNot:
+		sql, err := c.compile(e.Expr)
+		if err != nil {
+			return "", err
+		}
+		return "NOT " + sql, nil
+
+	case *FilterComparison:
+		err := checkComparison(e)
+		if err != nil {
+			return "", err
+		}
+		// comparisons with NULL count as false, so that not is the exact opposite
+		return "coalesce(" + c.comparison(e) + ", false)", nil
+
+	default:
+		return "", fmt.Errorf("unexpected filter expression %T", expr)
+	}
+}
+
+func (c *filterCompiler) join(left FilterExpr, joiner string, right FilterExpr) (string, error) {
+
+	l, err := c.compile(left)
+	if err != nil {
+		return "", err
+	}
+
+	r, err := c.compile(right)
+	if err != nil {
+		return "", err
+	}
+	return "(" + l + joiner + r + ")", nil
+}
+
+// comparison compiles a comparison which has been checked already.
+func (c *filterCompiler) comparison(cmp *FilterComparison) string {
+
+	field := filterFields[cmp.Field]
+	value := cmp.Values[0]
+
+	if field.kind == fieldTag {
+		names := make([]string, len(cmp.Values))
+		for i, v := range cmp.Values {
+			names[i] = v.Text
+		}
+
+		sql := `EXISTS (
+			SELECT 1
+			FROM todo_tags
+				INNER JOIN tags ON tags.id = todo_tags.tag_id
+			WHERE todo_tags.todo_id = todo.id
+				AND tags.name = ANY(` + c.arg(pq.Array(names)) + `::citext[]))`
+
+		if cmp.Op == "ne" {
+			return "NOT " + sql
+		}
+		return sql
+	}
+
+	if value.isNull() {
+		if cmp.Op == "eq" {
+			return field.sql + " IS NULL"
+		}
+		return field.sql + " IS NOT NULL"
+	}
+
+	if cmp.Op == "in" {
+		return c.in(field, cmp.Values)
+	}
+
+	arg, _ := filterArg(field.kind, value)
+
+	switch field.kind {
+	case fieldText:
+		text := value.Text
+		switch cmp.Op {
+		case "contains":
+			return field.sql + " ILIKE " + c.arg("%"+escapeLike(text)+"%")
+		case "startswith":
+			return field.sql + " ILIKE " + c.arg(escapeLike(text)+"%")
+		case "endswith":
+			return field.sql + " ILIKE " + c.arg("%"+escapeLike(text))
+		case "matches":
+			return "to_tsvector('simple', " + field.sql + ") @@ plainto_tsquery('simple', " + c.arg(text) + ")"
+		}
+		return field.sql + " " + sqlOps[cmp.Op] + " " + c.arg(text)
+
+	case fieldBool:
+		return field.sql + " " + sqlOps[cmp.Op] + " " + c.arg(arg) + "::boolean"
+
+	case fieldTime:
+		return c.time(field, cmp.Op, value, arg)
+
+	default:
+		return field.sql + " " + sqlOps[cmp.Op] + " " + c.arg(arg)
+	}
+}
+
+// time compiles a comparison of a time field. Dates, and today, compare the calendar
+// day in the user's time zone, anything else the exact time.
+func (c *filterCompiler) time(field filterField, op string, value FilterValue, arg any) string {
+
+	day := "(" + field.sql + " AT TIME ZONE settings.time_zone)::date"
+
+	switch strings.ToLower(value.Text) {
+	case "today":
+		return day + " " + sqlOps[op] + " (now() AT TIME ZONE settings.time_zone)::date"
+	case "now":
+		return field.sql + " " + sqlOps[op] + " now()"
+	}
+
+	if date, ok := arg.(string); ok {
+		return day + " " + sqlOps[op] + " " + c.arg(date) + "::date"
+	}
+	return field.sql + " " + sqlOps[op] + " " + c.arg(arg) + "::timestamptz"
+}
+
+// in compiles an in comparison, which matches any of the values.
+func (c *filterCompiler) in(field filterField, values []FilterValue) string {
+
+	switch field.kind {
+	case fieldText:
+		texts := make([]string, len(values))
+		for i, v := range values {
+			texts[i] = v.Text
+		}
+		return field.sql + " = ANY(" + c.arg(pq.Array(texts)) + "::text[])"
+
+	default:
+		numbers := make([]int64, len(values))
+		for i, v := range values {
+			arg, _ := filterArg(field.kind, v)
+			numbers[i] = arg.(int64)
+		}
+		return field.sql + " = ANY(" + c.arg(pq.Array(numbers)) + "::bigint[])"
+	}
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern, so that they match themselves.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
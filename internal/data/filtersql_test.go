@@ -0,0 +1,353 @@
+package data
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// compileFilter parses and compiles a filter the way GetAll does, with the user as the
+// first argument.
+func compileFilter(t *testing.T, input string) (string, []any) {
+	t.Helper()
+
+	expr, err := ParseFilter(input)
+	if err != nil {
+		t.Fatalf("ParseFilter(%q) returned error: %v", input, err)
+	}
+
+	c := &filterCompiler{args: []any{int64(7)}}
+
+	sql, err := c.compile(expr)
+	if err != nil {
+		t.Fatalf("compile(%q) returned error: %v", input, err)
+	}
+	return sql, c.args
+}
+
+func TestFilterCompile(t *testing.T) {
+
+	rfc3339 := time.Date(2024, 3, 1, 10, 0, 0, 0, time.FixedZone("", 3600))
+
+	tests := []struct {
+		name  string
+		input string
+		sql   string
+		args  []any
+	}{
+		{
+			name:  "bool",
+			input: "completed eq false",
+			sql:   "coalesce(todo.completed = $2::boolean, false)",
+			args:  []any{false},
+		},
+		{
+			name:  "ne is null safe",
+			input: "completed ne TRUE",
+			sql:   "coalesce(todo.completed IS DISTINCT FROM $2::boolean, false)",
+			args:  []any{true},
+		},
+		{
+			name:  "number",
+			input: "id le 42",
+			sql:   "coalesce(todo.id <= $2, false)",
+			args:  []any{int64(42)},
+		},
+		{
+			name:  "priority compares ranks",
+			input: "priority ge High",
+			sql:   "coalesce(todo.priority >= $2, false)",
+			args:  []any{int64(PriorityHigh)},
+		},
+		{
+			name:  "text",
+			input: `title eq "Buy milk"`,
+			sql:   "coalesce(todo.title = $2, false)",
+			args:  []any{"Buy milk"},
+		},
+		{
+			name:  "contains escapes wildcards",
+			input: `title contains "50%_off\\"`,
+			sql:   "coalesce(todo.title ILIKE $2, false)",
+			args:  []any{`%50\%\_off\\%`},
+		},
+		{
+			name:  "startswith",
+			input: "description startswith abc",
+			sql:   "coalesce(todo.description ILIKE $2, false)",
+			args:  []any{"abc%"},
+		},
+		{
+			name:  "endswith",
+			input: "description endswith abc",
+			sql:   "coalesce(todo.description ILIKE $2, false)",
+			args:  []any{"%abc"},
+		},
+		{
+			name:  "matches",
+			input: `title matches "quarterly report"`,
+			sql:   "coalesce(to_tsvector('simple', todo.title) @@ plainto_tsquery('simple', $2), false)",
+			args:  []any{"quarterly report"},
+		},
+		{
+			name:  "text in",
+			input: `title in (a, "b c")`,
+			sql:   "coalesce(todo.title = ANY($2::text[]), false)",
+			args:  []any{pq.Array([]string{"a", "b c"})},
+		},
+		{
+			name:  "number in",
+			input: "project_id in (1, 2, 3)",
+			sql:   "coalesce(todo.project_id = ANY($2::bigint[]), false)",
+			args:  []any{pq.Array([]int64{1, 2, 3})},
+		},
+		{
+			name:  "priority in",
+			input: "priority in (none, urgent)",
+			sql:   "coalesce(todo.priority = ANY($2::bigint[]), false)",
+			args:  []any{pq.Array([]int64{int64(PriorityNone), int64(PriorityUrgent)})},
+		},
+		{
+			name:  "eq null",
+			input: "due_at eq null",
+			sql:   "coalesce(todo.due_at IS NULL, false)",
+		},
+		{
+			name:  "ne null",
+			input: "project_id ne null",
+			sql:   "coalesce(todo.project_id IS NOT NULL, false)",
+		},
+		{
+			name:  "date compares days in the user's time zone",
+			input: "due_at lt 2024-03-01",
+			sql:   "coalesce((todo.due_at AT TIME ZONE settings.time_zone)::date < $2::date, false)",
+			args:  []any{"2024-03-01"},
+		},
+		{
+			name:  "today",
+			input: "completed_at eq today",
+			sql:   "coalesce((todo.completed_at AT TIME ZONE settings.time_zone)::date = (now() AT TIME ZONE settings.time_zone)::date, false)",
+		},
+		{
+			name:  "now",
+			input: "due_at le NOW",
+			sql:   "coalesce(todo.due_at <= now(), false)",
+		},
+		{
+			name:  "RFC 3339 time compares exact times",
+			input: "creation_time ge 2024-03-01T10:00:00+01:00",
+			sql:   "coalesce(todo.creation_time >= $2::timestamptz, false)",
+			args:  []any{rfc3339},
+		},
+		{
+			name:  "not keeps the coalesce inside",
+			input: "not due_at lt today",
+			sql:   "NOT coalesce((todo.due_at AT TIME ZONE settings.time_zone)::date < (now() AT TIME ZONE settings.time_zone)::date, false)",
+		},
+		{
+			name:  "not of a group",
+			input: "not (completed eq true or archived eq true)",
+			sql:   "NOT (coalesce(todo.completed = $2::boolean, false) OR coalesce(todo.archived = $3::boolean, false))",
+			args:  []any{true, true},
+		},
+		{
+			name:  "arguments are numbered in order",
+			input: "title contains a and (priority ge high or id in (1, 2)) and completed eq false",
+			sql: "((coalesce(todo.title ILIKE $2, false) AND " +
+				"(coalesce(todo.priority >= $3, false) OR coalesce(todo.id = ANY($4::bigint[]), false))) AND " +
+				"coalesce(todo.completed = $5::boolean, false))",
+			args: []any{"%a%", int64(PriorityHigh), pq.Array([]int64{1, 2}), false},
+		},
+		{
+			name:  "comparisons without arguments don't use up numbers",
+			input: "due_at eq null and due_at le now and id eq 3",
+			sql:   "((coalesce(todo.due_at IS NULL, false) AND coalesce(todo.due_at <= now(), false)) AND coalesce(todo.id = $2, false))",
+			args:  []any{int64(3)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			sql, args := compileFilter(t, tt.input)
+
+			if sql != tt.sql {
+				t.Errorf("compile(%q)\n got SQL %s\nwant SQL %s", tt.input, sql, tt.sql)
+			}
+
+			// the user stays the first argument
+			want := append([]any{int64(7)}, tt.args...)
+			if !reflect.DeepEqual(args, want) {
+				t.Errorf("compile(%q)\n got args %#v\nwant args %#v", tt.input, args, want)
+			}
+		})
+	}
+}
+
+func TestFilterCompileTags(t *testing.T) {
+
+	tests := []struct {
+		input string
+		not   bool
+		names []string
+	}{
+		{"tag eq work", false, []string{"work"}},
+		{`tag in (work, "side project")`, false, []string{"work", "side project"}},
+		{"tag ne work", true, []string{"work"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+
+			sql, args := compileFilter(t, tt.input)
+
+			if !strings.HasPrefix(sql, "coalesce(") || !strings.HasSuffix(sql, ", false)") {
+				t.Errorf("compile(%q) = %s, want it wrapped in coalesce", tt.input, sql)
+			}
+			if got := strings.Contains(sql, "coalesce(NOT EXISTS ("); got != tt.not {
+				t.Errorf("compile(%q) = %s, negated %v, want %v", tt.input, sql, got, tt.not)
+			}
+			if !strings.Contains(sql, "tags.name = ANY($2::citext[])") {
+				t.Errorf("compile(%q) = %s, want the names compared as $2", tt.input, sql)
+			}
+
+			want := []any{int64(7), pq.Array(tt.names)}
+			if !reflect.DeepEqual(args, want) {
+				t.Errorf("compile(%q) args = %#v, want %#v", tt.input, args, want)
+			}
+		})
+	}
+}
+
+// TestFilterCompileChecks makes sure that expressions which didn't come from
+// ParseFilter, such as the ones built by Queries.expr(), are checked as well.
+func TestFilterCompileChecks(t *testing.T) {
+
+	tests := []struct {
+		name string
+		expr FilterExpr
+		want string
+	}{
+		{"unknown field", compare("user_id", "eq", bare("1")), `unknown field "user_id"`},
+		{"bad operator", compare("completed", "lt", bare("true")), `"lt" can't be used with completed`},
+		{"bad value", compare("id", "eq", bare("1; DROP TABLE todo")), "is not a whole number"},
+		{"no values", compare("id", "eq"), "wrong number of values for id"},
+		{"several values without in", compare("id", "eq", bare("1"), bare("2")), "wrong number of values for id"},
+		{"nested", &FilterNot{Expr: &FilterOr{Left: compare("id", "eq", bare("1")), Right: compare("nope", "eq", bare("1"))}}, `unknown field "nope"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &filterCompiler{}
+			_, err := c.compile(tt.expr)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("compile() returned error %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueriesExpr(t *testing.T) {
+
+	completed := false
+	archived := false
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	q := Queries{
+		Search: Search{Title: "report"},
+		Filters: Filters{
+			Completed:  &completed,
+			Archived:   &archived,
+			StartDate:  start,
+			DueBefore:  time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+			Priorities: []Priority{PriorityHigh, PriorityUrgent},
+			Inbox:      true,
+		},
+	}
+
+	c := &filterCompiler{args: []any{int64(7)}}
+
+	sql, err := c.compile(q.expr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "(((((" +
+		"coalesce(to_tsvector('simple', todo.title) @@ plainto_tsquery('simple', $2), false) AND " +
+		"coalesce(todo.completed = $3::boolean, false)) AND " +
+		"coalesce(todo.archived = $4::boolean, false)) AND " +
+		"coalesce(todo.creation_time >= $5::timestamptz, false)) AND " +
+		"coalesce((todo.due_at AT TIME ZONE settings.time_zone)::date < $6::date, false)) AND " +
+		"coalesce(todo.priority = ANY($7::bigint[]), false)) AND " +
+		"coalesce(todo.project_id IS NULL, false)"
+	want = "(" + want + ")"
+
+	if sql != want {
+		t.Errorf("got SQL\n%s\nwant SQL\n%s", sql, want)
+	}
+
+	wantArgs := []any{
+		int64(7),
+		"report",
+		false,
+		false,
+		start,
+		"2024-02-01",
+		pq.Array([]int64{int64(PriorityHigh), int64(PriorityUrgent)}),
+	}
+	if !reflect.DeepEqual(c.args, wantArgs) {
+		t.Errorf("got args %#v\nwant args %#v", c.args, wantArgs)
+	}
+}
+
+func TestQueriesExprWithFilter(t *testing.T) {
+
+	filter, err := ParseFilter("archived eq true or priority eq urgent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	completed := true
+	q := Queries{Filters: Filters{Completed: &completed}, Filter: filter}
+
+	c := &filterCompiler{args: []any{int64(7)}}
+
+	sql, err := c.compile(q.expr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the filter comes last, grouped as a whole
+	want := "(coalesce(todo.completed = $2::boolean, false) AND " +
+		"(coalesce(todo.archived = $3::boolean, false) OR coalesce(todo.priority = $4, false)))"
+	if sql != want {
+		t.Errorf("got SQL\n%s\nwant SQL\n%s", sql, want)
+	}
+
+	// no criteria at all match every todo
+	c = &filterCompiler{}
+	if sql, err := c.compile(Queries{}.expr()); err != nil || sql != "true" {
+		t.Errorf("empty queries compiled to %q, %v; want \"true\"", sql, err)
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+
+	tests := map[string]string{
+		"plain":   "plain",
+		"50%":     `50\%`,
+		"a_b":     `a\_b`,
+		`back\`:   `back\\`,
+		`\%_`:     `\\\%\_`,
+		"ünïcødé": "ünïcødé",
+	}
+
+	for input, want := range tests {
+		if got := escapeLike(input); got != want {
+			t.Errorf("escapeLike(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrDuplicateIdentity is returned when the external account is already linked to a
+// user.
+var ErrDuplicateIdentity = errors.New("duplicate identity")
+
+// Identity links an account at an external identity provider to one of our users.
+type Identity struct {
+	ID           int64      `json:"id"`
+	UserID       int64      `json:"-"`
+	Provider     string     `json:"provider"`
+	Subject      string     `json:"-"`
+	Email        string     `json:"email"`
+	CreationTime time.Time  `json:"creation_time"`
+	LastLoginAt  *time.Time `json:"last_login_at"`
+}
+
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+// GetUser returns the user linked to the subject at the provider, and records the
+// login.
+func (m *IdentityModel) GetUser(provider, subject string) (*User, error) {
+
+	query := `
+		UPDATE user_identities
+			SET last_login_at = now()
+			WHERE provider = $1 AND subject = $2
+		RETURNING user_id
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var userID int64
+
+	err := m.DB.QueryRowContext(ctx, query, provider, subject).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	users := UserModel{DB: m.DB}
+	return users.Get(userID)
+}
+
+func (m *IdentityModel) Insert(identity *Identity) error {
+
+	query := `
+		INSERT INTO user_identities (user_id, provider, subject, email, last_login_at)
+			VALUES ($1, $2, $3, $4, now())
+		RETURNING id, creation_time, last_login_at
+	`
+	args := []any{identity.UserID, identity.Provider, identity.Subject, identity.Email}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&identity.ID, &identity.CreationTime, &identity.LastLoginAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "user_identities_provider_subject_key"`:
+			return ErrDuplicateIdentity
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LoginState is what we need to remember between sending the user to an identity
+// provider and them coming back with an authorization code. It is looked up by the
+// state parameter, of which only the hash is stored.
+type LoginState struct {
+	State        string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	Expiry       time.Time
+}
+
+type LoginStateModel struct {
+	DB *sql.DB
+}
+
+func (m *LoginStateModel) Insert(s *LoginState) error {
+
+	hash := sha256.Sum256([]byte(s.State))
+
+	query := `
+		INSERT INTO oidc_login_states (hash, provider, nonce, code_verifier, expiry)
+			VALUES ($1, $2, $3, $4, $5)
+	`
+	args := []any{hash[:], s.Provider, s.Nonce, s.CodeVerifier, s.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Consume returns and deletes the login state, so that every state can only complete
+// a single login. Expired states aren't returned.
+func (m *LoginStateModel) Consume(provider, state string) (*LoginState, error) {
+
+	hash := sha256.Sum256([]byte(state))
+
+	query := `
+		DELETE FROM oidc_login_states
+			WHERE hash = $1 AND provider = $2
+		RETURNING nonce, code_verifier, expiry
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	s := LoginState{State: state, Provider: provider}
+
+	err := m.DB.QueryRowContext(ctx, query, hash[:], provider).Scan(&s.Nonce, &s.CodeVerifier, &s.Expiry)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if time.Now().After(s.Expiry) {
+		return nil, ErrRecordNotFound
+	}
+
+	return &s, nil
+}
+
+// DeleteExpired removes the states of logins which were never completed.
+func (m *LoginStateModel) DeleteExpired() error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM oidc_login_states WHERE expiry <= $1`, time.Now())
+	return err
+}
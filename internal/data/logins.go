@@ -0,0 +1,109 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// LoginFailureModel counts the failed login attempts per key, where a key is either
+// an account or a client IP address. The lockout policy itself is left to the caller.
+type LoginFailureModel struct {
+	DB *sql.DB
+}
+
+// LockedUntil returns the latest time any of the keys is locked until, or the zero
+// time if none of them is locked.
+func (m *LoginFailureModel) LockedUntil(keys ...string) (time.Time, error) {
+
+	query := `
+		SELECT COALESCE(max(locked_until), 'epoch')
+		FROM login_failures
+			WHERE key = ANY($1) AND locked_until > now()
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var lockedUntil time.Time
+
+	err := m.DB.QueryRowContext(ctx, query, pq.Array(keys)).Scan(&lockedUntil)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if !lockedUntil.After(time.Now()) {
+		return time.Time{}, nil
+	}
+
+	return lockedUntil, nil
+}
+
+// RecordFailure counts a failed attempt for the key and returns the number of
+// consecutive failures. The count starts over once the last failure is older than
+// window.
+func (m *LoginFailureModel) RecordFailure(key string, window time.Duration) (int, error) {
+
+	query := `
+		INSERT INTO login_failures (key, failures)
+			VALUES ($1, 1)
+		ON CONFLICT (key) DO UPDATE
+			SET
+				failures = CASE
+					WHEN login_failures.last_failure < $2 THEN 1
+					ELSE login_failures.failures + 1
+				END,
+				last_failure = now()
+		RETURNING failures
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var failures int
+
+	err := m.DB.QueryRowContext(ctx, query, key, time.Now().Add(-window)).Scan(&failures)
+	if err != nil {
+		return 0, err
+	}
+
+	return failures, nil
+}
+
+// Lock locks the key until the given time.
+func (m *LoginFailureModel) Lock(key string, until time.Time) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE login_failures SET locked_until = $2 WHERE key = $1`, key, until)
+	return err
+}
+
+// Reset forgets the failures of the key, after a successful login.
+func (m *LoginFailureModel) Reset(key string) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM login_failures WHERE key = $1`, key)
+	return err
+}
+
+// DeleteExpired removes the keys whose failures are older than window and which are
+// no longer locked.
+func (m *LoginFailureModel) DeleteExpired(window time.Duration) error {
+
+	query := `
+		DELETE FROM login_failures
+			WHERE last_failure < $1 AND (locked_until IS NULL OR locked_until < now())
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now().Add(-window))
+	return err
+}
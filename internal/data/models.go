@@ -13,15 +13,43 @@ var (
 )
 
 type Models struct {
-	Todo  TodoModel
-	User  UserModel
-	Token TokenModel
+	Todo        TodoModel
+	User        UserModel
+	Token       TokenModel
+	Denylist    DenylistModel
+	Permission  PermissionModel
+	Audit       AuditModel
+	TOTP        TOTPModel
+	Login       LoginFailureModel
+	Identity    IdentityModel
+	LoginState  LoginStateModel
+	OAuthClient OAuthClientModel
+	OAuthCode   AuthorizationCodeModel
+	Deletion    AccountDeletionModel
+	Export      ExportModel
+	Tag         TagModel
+	Project     ProjectModel
+	Reminder    ReminderModel
 }
 
 func NewModels(db *sql.DB) *Models {
 	return &Models{
-		Todo:  TodoModel{DB: db},
-		User:  UserModel{DB: db},
-		Token: TokenModel{DB: db},
+		Todo:        TodoModel{DB: db},
+		User:        UserModel{DB: db},
+		Token:       TokenModel{DB: db},
+		Denylist:    DenylistModel{DB: db},
+		Permission:  PermissionModel{DB: db},
+		Audit:       AuditModel{DB: db},
+		TOTP:        TOTPModel{DB: db},
+		Login:       LoginFailureModel{DB: db},
+		Identity:    IdentityModel{DB: db},
+		LoginState:  LoginStateModel{DB: db},
+		OAuthClient: OAuthClientModel{DB: db},
+		OAuthCode:   AuthorizationCodeModel{DB: db},
+		Deletion:    AccountDeletionModel{DB: db},
+		Export:      ExportModel{DB: db},
+		Tag:         TagModel{DB: db},
+		Project:     ProjectModel{DB: db},
+		Reminder:    ReminderModel{DB: db},
 	}
 }
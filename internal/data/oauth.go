@@ -0,0 +1,321 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/ridwanulhoquejr/todo-app/internal/validator"
+)
+
+// OAuthClient is a third-party application registered by a user, which other users
+// can then grant access to their todos. Public clients, such as mobile apps, can't
+// keep a secret and have none; they rely on PKCE alone.
+type OAuthClient struct {
+	ID           string    `json:"client_id"`
+	Secret       string    `json:"client_secret,omitempty"`
+	SecretHash   []byte    `json:"-"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Confidential bool      `json:"confidential"`
+	UserID       int64     `json:"-"`
+	CreationTime time.Time `json:"creation_time"`
+}
+
+// SecretMatches checks the client secret, in constant time.
+func (c *OAuthClient) SecretMatches(secret string) bool {
+	if !c.Confidential {
+		return false
+	}
+	hash := sha256.Sum256([]byte(secret))
+	return subtle.ConstantTimeCompare(hash[:], c.SecretHash) == 1
+}
+
+// HasRedirectURI reports whether uri is registered for the client. Redirect URIs are
+// compared as plain strings, as RFC 6749 section 3.1.2.3 requires.
+func (c *OAuthClient) HasRedirectURI(uri string) bool {
+	for _, u := range c.RedirectURIs {
+		if u == uri {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidateOAuthClient(v *validator.Validator, client *OAuthClient) {
+
+	v.Check(client.Name != "", "name", "must be provided")
+	v.Check(len(client.Name) <= 100, "name", "must not be more than 100 characters long")
+
+	v.Check(len(client.RedirectURIs) > 0, "redirect_uris", "must contain at least one URI")
+	v.Check(len(client.RedirectURIs) <= 10, "redirect_uris", "must not contain more than 10 URIs")
+	v.Check(validator.Unique(client.RedirectURIs), "redirect_uris", "must not contain duplicate values")
+
+	for _, uri := range client.RedirectURIs {
+		v.Check(validRedirectURI(uri), "redirect_uris", "must be absolute URIs without a fragment, using https unless on a loopback address")
+	}
+}
+
+// validRedirectURI follows RFC 8252 for native apps: plain http is only allowed on a
+// loopback address, private schemes such as com.example.app:/callback are fine.
+func validRedirectURI(uri string) bool {
+
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Fragment != "" {
+		return false
+	}
+
+	switch u.Scheme {
+	case "https":
+		return u.Host != ""
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	default:
+		return true
+	}
+}
+
+// ValidateOAuthScopes checks the scopes requested by a client. They are the same as
+// the ones of personal access tokens.
+func ValidateOAuthScopes(v *validator.Validator, scopes []string) {
+	v.Check(len(scopes) > 0, "scope", "must contain at least one scope")
+	for _, scope := range scopes {
+		v.Check(validator.In(scope, APIScopes...), "scope", "must only contain known scopes")
+	}
+}
+
+type OAuthClientModel struct {
+	DB *sql.DB
+}
+
+// Insert creates the client with a random ID, and a random secret if it is
+// confidential. The plaintext secret is only ever available on the returned client.
+func (m *OAuthClientModel) Insert(client *OAuthClient) error {
+
+	id, err := randomString()
+	if err != nil {
+		return err
+	}
+	client.ID = id
+
+	if client.Confidential {
+		secret, err := randomString()
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256([]byte(secret))
+		client.Secret = secret
+		client.SecretHash = hash[:]
+	}
+
+	query := `
+		INSERT INTO oauth_clients (id, secret_hash, name, redirect_uris, user_id)
+			VALUES ($1, $2, $3, $4, $5)
+		RETURNING creation_time
+	`
+	args := []any{client.ID, client.SecretHash, client.Name, pq.Array(client.RedirectURIs), client.UserID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&client.CreationTime)
+}
+
+func (m *OAuthClientModel) Get(id string) (*OAuthClient, error) {
+
+	query := `
+		SELECT id, secret_hash, name, redirect_uris, user_id, creation_time
+		FROM oauth_clients
+			WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var client OAuthClient
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&client.ID,
+		&client.SecretHash,
+		&client.Name,
+		pq.Array(&client.RedirectURIs),
+		&client.UserID,
+		&client.CreationTime,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	client.Confidential = client.SecretHash != nil
+
+	return &client, nil
+}
+
+// GetAllForUser returns the clients registered by the user.
+func (m *OAuthClientModel) GetAllForUser(userID int64) ([]*OAuthClient, error) {
+
+	query := `
+		SELECT id, secret_hash IS NOT NULL, name, redirect_uris, user_id, creation_time
+		FROM oauth_clients
+			WHERE user_id = $1
+		ORDER BY creation_time DESC
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []*OAuthClient{}
+
+	for rows.Next() {
+		var client OAuthClient
+		err := rows.Scan(
+			&client.ID,
+			&client.Confidential,
+			&client.Name,
+			pq.Array(&client.RedirectURIs),
+			&client.UserID,
+			&client.CreationTime,
+		)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, &client)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return clients, nil
+}
+
+// Delete removes a client of the user. Its codes and tokens go along with it.
+func (m *OAuthClientModel) Delete(id string, userID int64) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM oauth_clients WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// AuthorizationCode is issued once the user consented to a client, and is exchanged
+// by the client for tokens. Only its hash is stored.
+type AuthorizationCode struct {
+	Plaintext     string
+	ClientID      string
+	UserID        int64
+	RedirectURI   string
+	Scopes        []string
+	CodeChallenge string
+	Expiry        time.Time
+}
+
+type AuthorizationCodeModel struct {
+	DB *sql.DB
+}
+
+// New creates and stores a code with a random plaintext.
+func (m *AuthorizationCodeModel) New(code *AuthorizationCode, ttl time.Duration) error {
+
+	plaintext, err := randomString()
+	if err != nil {
+		return err
+	}
+	code.Plaintext = plaintext
+	code.Expiry = time.Now().Add(ttl)
+
+	hash := sha256.Sum256([]byte(code.Plaintext))
+
+	query := `
+		INSERT INTO oauth_authorization_codes (hash, client_id, user_id, redirect_uri, scopes, code_challenge, expiry)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	args := []any{hash[:], code.ClientID, code.UserID, code.RedirectURI, pq.Array(code.Scopes), code.CodeChallenge, code.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Consume returns and deletes the code of the client, so that it can only be
+// exchanged once.
+func (m *AuthorizationCodeModel) Consume(plaintext, clientID string) (*AuthorizationCode, error) {
+
+	hash := sha256.Sum256([]byte(plaintext))
+
+	query := `
+		DELETE FROM oauth_authorization_codes
+			WHERE hash = $1 AND client_id = $2
+		RETURNING user_id, redirect_uri, scopes, code_challenge, expiry
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	code := AuthorizationCode{Plaintext: plaintext, ClientID: clientID}
+
+	err := m.DB.QueryRowContext(ctx, query, hash[:], clientID).Scan(
+		&code.UserID,
+		&code.RedirectURI,
+		pq.Array(&code.Scopes),
+		&code.CodeChallenge,
+		&code.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if time.Now().After(code.Expiry) {
+		return nil, ErrRecordNotFound
+	}
+
+	return &code, nil
+}
+
+// DeleteExpired removes the codes which were never exchanged.
+func (m *AuthorizationCodeModel) DeleteExpired() error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM oauth_authorization_codes WHERE expiry <= $1`, time.Now())
+	return err
+}
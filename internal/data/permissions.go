@@ -0,0 +1,132 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Permission codes, as seeded in the permissions table.
+const (
+	PermissionTodosRead  = "todos:read"
+	PermissionTodosWrite = "todos:write"
+	PermissionUsersAdmin = "users:admin"
+)
+
+// Roles seeded in the roles table.
+const (
+	RoleMember = "member"
+	RoleAdmin  = "admin"
+)
+
+// Roles lists every role which can be granted to a user.
+var Roles = []string{RoleMember, RoleAdmin}
+
+// Define a Permissions slice, which we will use to hold the permission codes (like
+// "todos:read" and "todos:write") for a single user.
+type Permissions []string
+
+// Add a helper method to check whether the Permissions slice contains a specific
+// permission code.
+func (p Permissions) Include(code string) bool {
+	for i := range p {
+		if code == p[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// Define the PermissionModel type.
+type PermissionModel struct {
+	DB *sql.DB
+}
+
+// The GetAllForUser() method returns all permission codes for a specific user in a
+// Permissions slice.
+func (m *PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+
+	query := `
+		SELECT permissions.code
+		FROM permissions
+			INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+			INNER JOIN users ON users_permissions.user_id = users.id
+		WHERE users.id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// AddForUser grants the given permission codes to a user. Codes the user already has
+// are skipped.
+func (m *PermissionModel) AddForUser(userID int64, codes ...string) error {
+
+	query := `
+		INSERT INTO users_permissions
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
+
+// AddRoleForUser grants every permission of the named role to a user.
+func (m *PermissionModel) AddRoleForUser(userID int64, role string) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return addRoleForUser(ctx, m.DB, userID, role)
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx, so that a role can be granted as
+// part of creating the user.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func addRoleForUser(ctx context.Context, db execer, userID int64, role string) error {
+
+	query := `
+		INSERT INTO users_permissions
+		SELECT $1, roles_permissions.permission_id
+		FROM roles_permissions
+			INNER JOIN roles ON roles.id = roles_permissions.role_id
+		WHERE roles.name = $2
+		ON CONFLICT DO NOTHING
+	`
+
+	_, err := db.ExecContext(ctx, query, userID, role)
+	return err
+}
@@ -0,0 +1,71 @@
+package data
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/ridwanulhoquejr/todo-app/internal/validator"
+)
+
+// Priority is the urgency of a todo. It is stored as its rank, so sorting on the
+// priority column orders todos from none up to urgent (or the reverse).
+type Priority int16
+
+const (
+	PriorityNone Priority = iota
+	PriorityLow
+	PriorityMedium
+	PriorityHigh
+	PriorityUrgent
+
+	// priorityInvalid is what an unknown priority name decodes to, so that it can be
+	// reported by ValidateTodo rather than as a malformed request body.
+	priorityInvalid Priority = -1
+)
+
+var priorityNames = []string{"none", "low", "medium", "high", "urgent"}
+
+// ParsePriority returns the priority with the given name, and false if there's none.
+func ParsePriority(name string) (Priority, bool) {
+	for i, n := range priorityNames {
+		if strings.EqualFold(name, n) {
+			return Priority(i), true
+		}
+	}
+	return priorityInvalid, false
+}
+
+func (p Priority) String() string {
+	if !p.valid() {
+		return "invalid"
+	}
+	return priorityNames[p]
+}
+
+// ValidatePriority checks that the priority is one of the known ones.
+func ValidatePriority(v *validator.Validator, p Priority) {
+	v.Check(p.valid(), "priority", "must be one of none, low, medium, high or urgent")
+}
+
+func (p Priority) valid() bool {
+	return p >= PriorityNone && p <= PriorityUrgent
+}
+
+// MarshalJSON writes the priority by name.
+func (p Priority) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.String())
+}
+
+// UnmarshalJSON reads the priority by name. A null leaves the priority as it was.
+func (p *Priority) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+
+	var name string
+	if err := json.Unmarshal(b, &name); err != nil {
+		return err
+	}
+	*p, _ = ParsePriority(name)
+	return nil
+}
@@ -0,0 +1,227 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/ridwanulhoquejr/todo-app/internal/validator"
+)
+
+// Project groups a user's todos. Todos which aren't in any project are in the inbox.
+// Projects are listed by Position, which the user is free to rearrange.
+type Project struct {
+	ID           int64     `json:"id"`
+	UserID       int64     `json:"-"`
+	Name         string    `json:"name"`
+	Color        string    `json:"color"`
+	Archived     bool      `json:"archived"`
+	Position     int32     `json:"position"`
+	Version      int32     `json:"version"`
+	CreationTime time.Time `json:"creation_time"`
+}
+
+type ProjectModel struct {
+	DB *sql.DB
+}
+
+// Insert adds a project, at the end of the user's list unless a position is given.
+func (m *ProjectModel) Insert(project *Project, atEnd bool) error {
+
+	query := `
+		INSERT INTO projects (user_id, name, color, archived, position)
+			VALUES ($1, $2, $3, $4, CASE WHEN $6
+				THEN (SELECT coalesce(max(position), 0) + 1 FROM projects WHERE user_id = $1)
+				ELSE $5
+			END)
+		RETURNING id, position, version, creation_time
+	`
+	args := []any{project.UserID, project.Name, project.Color, project.Archived, project.Position, atEnd}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&project.ID,
+		&project.Position,
+		&project.Version,
+		&project.CreationTime,
+	)
+}
+
+func (m *ProjectModel) Get(id, userID int64) (*Project, error) {
+
+	query := `
+		SELECT id, user_id, name, color, archived, position, version, creation_time
+		FROM projects
+		WHERE id = $1
+			AND user_id = $2
+	`
+
+	var project Project
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(
+		&project.ID,
+		&project.UserID,
+		&project.Name,
+		&project.Color,
+		&project.Archived,
+		&project.Position,
+		&project.Version,
+		&project.CreationTime,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &project, nil
+}
+
+// GetAllForUser returns the user's projects in their order, leaving out the archived
+// ones unless asked for.
+func (m *ProjectModel) GetAllForUser(userID int64, withArchived bool) ([]*Project, error) {
+
+	query := `
+		SELECT id, user_id, name, color, archived, position, version, creation_time
+		FROM projects
+		WHERE user_id = $1
+			AND (NOT archived OR $2)
+		ORDER BY position, id
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, withArchived)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	projects := []*Project{}
+
+	for rows.Next() {
+		var project Project
+
+		err := rows.Scan(
+			&project.ID,
+			&project.UserID,
+			&project.Name,
+			&project.Color,
+			&project.Archived,
+			&project.Position,
+			&project.Version,
+			&project.CreationTime,
+		)
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, &project)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return projects, nil
+}
+
+func (m *ProjectModel) Update(project *Project) error {
+
+	query := `
+		UPDATE projects
+			SET name = $1, color = $2, archived = $3, position = $4, version = version + 1
+			WHERE id = $5
+				AND user_id = $6
+				AND version = $7
+		RETURNING version
+	`
+	args := []any{
+		project.Name,
+		project.Color,
+		project.Archived,
+		project.Position,
+		project.ID,
+		project.UserID,
+		project.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&project.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes a project. Its todos either go back to the inbox (which the foreign
+// key takes care of) or, with deleteTodos, are moved to the trash along with their
+// subtasks, the same way TodoModel.Delete() does it. Restored todos end up in the
+// inbox, as the project is gone by then.
+func (m *ProjectModel) Delete(id, userID int64, deleteTodos bool) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if deleteTodos {
+		query := `
+			WITH RECURSIVE subtree AS (
+				SELECT id FROM todo WHERE project_id = $1 AND user_id = $2 AND deleted_at IS NULL
+				UNION
+				SELECT todo.id FROM todo INNER JOIN subtree ON todo.parent_id = subtree.id
+				WHERE todo.deleted_at IS NULL
+			)
+			UPDATE todo
+				SET deleted_at = now(), version = version + 1
+				WHERE id IN (SELECT id FROM subtree)
+		`
+
+		_, err = tx.ExecContext(ctx, query, id, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM projects WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+func ValidateProject(v *validator.Validator, project *Project) {
+	v.Check(project.Name != "", "name", "must be provided")
+	v.Check(len(project.Name) <= 100, "name", "must not be more than 100 characters long")
+	v.Check(validator.Matches(project.Color, validator.ColorRX), "color", "must be a hex color such as #1e90ff")
+	v.Check(project.Position >= 0, "position", "must not be negative")
+}
@@ -0,0 +1,116 @@
+package data
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ridwanulhoquejr/todo-app/internal/rrule"
+	"github.com/ridwanulhoquejr/todo-app/internal/validator"
+)
+
+// ErrSeriesEnded is returned when a recurring todo has no occurrences left.
+var ErrSeriesEnded = errors.New("series has no more occurrences")
+
+// Recurrence makes a todo repeat. The RRULE is evaluated in TimeZone, with the due
+// date of the todo as its start, so every occurrence keeps the same wall clock time.
+// When the rule has a COUNT, it's the number of occurrences left, counting the one
+// of the todo.
+type Recurrence struct {
+	RRule    string `json:"rrule"`
+	TimeZone string `json:"time_zone"`
+}
+
+func (r *Recurrence) parse() (*rrule.Rule, *time.Location, error) {
+
+	rule, err := rrule.Parse(r.RRule)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	loc, err := time.LoadLocation(r.TimeZone)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rule, loc, nil
+}
+
+// Occurrences returns up to n occurrences of a recurring todo after its due date.
+func (t *Todo) Occurrences(n int) ([]time.Time, error) {
+
+	if t.Recurrence == nil || t.DueAt == nil {
+		return []time.Time{}, nil
+	}
+
+	rule, loc, err := t.Recurrence.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	occurrences := rule.Next(t.DueAt.In(loc), n)
+	if occurrences == nil {
+		occurrences = []time.Time{}
+	}
+	return occurrences, nil
+}
+
+// NextOccurrence returns a new todo for the occurrence after this one, which carries
+// the series on. If there is none ErrSeriesEnded is returned.
+func (t *Todo) NextOccurrence() (*Todo, error) {
+
+	if t.Recurrence == nil || t.DueAt == nil {
+		return nil, ErrSeriesEnded
+	}
+
+	rule, loc, err := t.Recurrence.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	occurrences := rule.Next(t.DueAt.In(loc), 1)
+	if len(occurrences) == 0 {
+		return nil, ErrSeriesEnded
+	}
+
+	// the occurrence of this todo is used up
+	if rule.Count > 0 {
+		rule.Count--
+	}
+
+	tags := make([]*Tag, len(t.Tags))
+	for i, tag := range t.Tags {
+		tags[i] = &Tag{Name: tag.Name}
+	}
+
+	return &Todo{
+		Title:      t.Title,
+		Descripton: t.Descripton,
+		UserID:     t.UserID,
+		ParentID:   t.ParentID,
+		Priority:   t.Priority,
+		ProjectID:  t.ProjectID,
+		DueAt:      &occurrences[0],
+		AllDay:     t.AllDay,
+		Tags:       tags,
+		Recurrence: &Recurrence{RRule: rule.String(), TimeZone: t.Recurrence.TimeZone},
+	}, nil
+}
+
+// ValidateRecurrence checks the recurrence of a todo, which needs a due date to start
+// from.
+func ValidateRecurrence(v *validator.Validator, todo *Todo) {
+
+	if todo.Recurrence == nil {
+		return
+	}
+
+	v.Check(todo.DueAt != nil, "recurrence", "requires due_at to be set")
+
+	_, err := rrule.Parse(todo.Recurrence.RRule)
+	if err != nil {
+		v.AddError("recurrence", err.Error())
+	}
+
+	_, err = time.LoadLocation(todo.Recurrence.TimeZone)
+	v.Check(todo.Recurrence.TimeZone != "" && todo.Recurrence.TimeZone != "Local" && err == nil,
+		"recurrence", "time_zone must be a valid IANA time zone")
+}
@@ -0,0 +1,63 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+// TestNextOccurrence completes a series one todo at a time, the way completing a
+// recurring todo does, until it runs out.
+func TestNextOccurrence(t *testing.T) {
+
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the last Friday of the month at 09:00 New York time, stored in UTC as the database
+	// would return it
+	dueAt := time.Date(2024, 2, 23, 9, 0, 0, 0, newYork).UTC()
+	todo := &Todo{
+		Title:      "Send the report",
+		UserID:     7,
+		DueAt:      &dueAt,
+		Tags:       []*Tag{{ID: 3, Name: "work"}},
+		Recurrence: &Recurrence{RRule: "FREQ=MONTHLY;BYDAY=-1FR;COUNT=3", TimeZone: "America/New_York"},
+	}
+
+	want := []struct {
+		dueAt time.Time
+		rrule string
+	}{
+		{time.Date(2024, 3, 29, 9, 0, 0, 0, newYork), "FREQ=MONTHLY;COUNT=2;BYDAY=-1FR"},
+		{time.Date(2024, 4, 26, 9, 0, 0, 0, newYork), "FREQ=MONTHLY;COUNT=1;BYDAY=-1FR"},
+	}
+
+	for _, w := range want {
+		next, err := todo.NextOccurrence()
+		if err != nil {
+			t.Fatalf("NextOccurrence() returned error: %v", err)
+		}
+
+		if !next.DueAt.Equal(w.dueAt) {
+			t.Errorf("next due at %s, want %s", next.DueAt, w.dueAt)
+		}
+		if next.Recurrence.RRule != w.rrule {
+			t.Errorf("next RRULE %q, want %q", next.Recurrence.RRule, w.rrule)
+		}
+		if next.Title != todo.Title || next.UserID != todo.UserID {
+			t.Errorf("next todo %+v doesn't carry on %+v", next, todo)
+		}
+		if len(next.Tags) != 1 || next.Tags[0].Name != "work" || next.Tags[0].ID != 0 {
+			t.Errorf("next tags %+v, want a new work tag", next.Tags)
+		}
+
+		todo = next
+	}
+
+	if _, err := todo.NextOccurrence(); !errors.Is(err, ErrSeriesEnded) {
+		t.Errorf("NextOccurrence() of the last todo returned error %v, want %v", err, ErrSeriesEnded)
+	}
+}
@@ -0,0 +1,378 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/ridwanulhoquejr/todo-app/internal/notify"
+	"github.com/ridwanulhoquejr/todo-app/internal/validator"
+)
+
+// Statuses of a reminder.
+const (
+	ReminderPending = "pending"
+	ReminderSending = "sending"
+	ReminderSent    = "sent"
+	ReminderFailed  = "failed"
+)
+
+// ReminderChannels are the channels a reminder can be delivered over.
+var ReminderChannels = []string{"email", "webhook", "log"}
+
+// reminderStuckAfter is how long a reminder may stay in sending before another worker
+// picks it up again, e.g. because the one delivering it was killed.
+const reminderStuckAfter = 5 * time.Minute
+
+// Reminder is a notification about a todo. It either fires at RemindAt, or
+// OffsetMinutes from the due date of the todo (negative is before it), in which case
+// it follows the todo when it's rescheduled.
+type Reminder struct {
+	ID            int64      `json:"id"`
+	TodoID        int64      `json:"todo_id"`
+	UserID        int64      `json:"-"`
+	RemindAt      *time.Time `json:"remind_at"`
+	OffsetMinutes *int32     `json:"offset_minutes"`
+	Channel       string     `json:"channel"`
+	WebhookURL    string     `json:"webhook_url,omitempty"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	SentAt        *time.Time `json:"sent_at"`
+	Error         string     `json:"error,omitempty"`
+	CreationTime  time.Time  `json:"creation_time"`
+}
+
+// DueReminder is a reminder claimed for delivery, along with what's needed to deliver
+// it.
+type DueReminder struct {
+	Reminder
+	Title    string
+	DueAt    *time.Time
+	AllDay   bool
+	Email    string
+	Name     string
+	TimeZone string
+}
+
+type ReminderModel struct {
+	DB *sql.DB
+}
+
+const reminderColumns = `
+	id, todo_id, user_id, remind_at, offset_minutes, channel, webhook_url, status,
+	attempts, sent_at, error, creation_time`
+
+// scanReminder reads a row of reminderColumns, followed by the extra values.
+func scanReminder(row scanner, extra ...any) (*Reminder, error) {
+
+	var reminder Reminder
+
+	dest := []any{
+		&reminder.ID,
+		&reminder.TodoID,
+		&reminder.UserID,
+		&reminder.RemindAt,
+		&reminder.OffsetMinutes,
+		&reminder.Channel,
+		&reminder.WebhookURL,
+		&reminder.Status,
+		&reminder.Attempts,
+		&reminder.SentAt,
+		&reminder.Error,
+		&reminder.CreationTime,
+	}
+
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	return &reminder, nil
+}
+
+func (m *ReminderModel) Insert(reminder *Reminder) error {
+
+	query := `
+		INSERT INTO reminders (todo_id, user_id, remind_at, offset_minutes, channel, webhook_url)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, status, attempts, creation_time
+	`
+	args := []any{
+		reminder.TodoID,
+		reminder.UserID,
+		reminder.RemindAt,
+		reminder.OffsetMinutes,
+		reminder.Channel,
+		reminder.WebhookURL,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&reminder.ID,
+		&reminder.Status,
+		&reminder.Attempts,
+		&reminder.CreationTime,
+	)
+}
+
+// GetAllForTodo returns the reminders of a todo, in the order they were added.
+func (m *ReminderModel) GetAllForTodo(todoID, userID int64) ([]*Reminder, error) {
+
+	query := `
+		SELECT ` + reminderColumns + `
+		FROM reminders
+		WHERE todo_id = $1
+			AND user_id = $2
+		ORDER BY id
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, todoID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reminders := []*Reminder{}
+
+	for rows.Next() {
+		reminder, err := scanReminder(rows)
+		if err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, reminder)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return reminders, nil
+}
+
+func (m *ReminderModel) Delete(id, todoID, userID int64) error {
+
+	query := `
+		DELETE FROM reminders
+		WHERE id = $1
+			AND todo_id = $2
+			AND user_id = $3
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, todoID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// ClaimDue marks up to limit reminders which are due as being sent and returns them.
+// FOR UPDATE SKIP LOCKED lets several instances of the API poll for reminders without
+// sending the same one twice. Reminders of completed todos, or of todos in the trash,
+// aren't sent.
+func (m *ReminderModel) ClaimDue(limit int) ([]*DueReminder, error) {
+
+	query := `
+		WITH claimed AS (
+			UPDATE reminders
+				SET status = $1, attempts = attempts + 1, claimed_at = now()
+				WHERE id IN (
+					SELECT reminders.id
+					FROM reminders
+						INNER JOIN todo ON todo.id = reminders.todo_id
+					WHERE NOT todo.completed
+						AND todo.deleted_at IS NULL
+						AND (
+							(reminders.status = $2 AND coalesce(
+								reminders.retry_at,
+								reminders.remind_at,
+								todo.due_at + reminders.offset_minutes * interval '1 minute'
+							) <= now())
+							OR (reminders.status = $1 AND reminders.claimed_at < $3)
+						)
+					ORDER BY reminders.id
+					LIMIT $4
+					FOR UPDATE OF reminders SKIP LOCKED
+				)
+			RETURNING ` + reminderColumns + `
+		)
+		SELECT claimed.*, todo.title, todo.due_at, todo.all_day, users.email, users.name, users.time_zone
+		FROM claimed
+			INNER JOIN todo ON todo.id = claimed.todo_id
+			INNER JOIN users ON users.id = claimed.user_id
+		ORDER BY claimed.id
+	`
+	args := []any{ReminderSending, ReminderPending, time.Now().Add(-reminderStuckAfter), limit}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	due := []*DueReminder{}
+
+	for rows.Next() {
+		var d DueReminder
+
+		reminder, err := scanReminder(rows, &d.Title, &d.DueAt, &d.AllDay, &d.Email, &d.Name, &d.TimeZone)
+		if err != nil {
+			return nil, err
+		}
+		d.Reminder = *reminder
+		due = append(due, &d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return due, nil
+}
+
+// MarkSent records the delivery of a reminder.
+func (m *ReminderModel) MarkSent(id int64) error {
+
+	query := `
+		UPDATE reminders
+			SET status = $2, sent_at = now(), error = ''
+			WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, ReminderSent)
+	return err
+}
+
+// Retry puts a reminder whose delivery failed back in line, to be tried again at
+// retryAt.
+func (m *ReminderModel) Retry(id int64, reason string, retryAt time.Time) error {
+
+	query := `
+		UPDATE reminders
+			SET status = $2, error = $3, retry_at = $4, claimed_at = NULL
+			WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, ReminderPending, reason, retryAt)
+	return err
+}
+
+// Fail gives up on a reminder.
+func (m *ReminderModel) Fail(id int64, reason string) error {
+
+	query := `
+		UPDATE reminders
+			SET status = $2, error = $3, claimed_at = NULL
+			WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, ReminderFailed, reason)
+	return err
+}
+
+// Release hands claimed reminders back without counting an attempt, for when the
+// application shuts down before it gets to them.
+func (m *ReminderModel) Release(ids []int64) error {
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query := `
+		UPDATE reminders
+			SET status = $2, attempts = attempts - 1, claimed_at = NULL
+			WHERE id = ANY($1)
+				AND status = $3
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, pq.Array(ids), ReminderPending, ReminderSending)
+	return err
+}
+
+// copyReminders gives the todo of the next occurrence of a recurring todo the
+// reminders which are relative to the due date.
+func copyReminders(ctx context.Context, tx *sql.Tx, fromTodoID, toTodoID int64) error {
+
+	query := `
+		INSERT INTO reminders (todo_id, user_id, offset_minutes, channel, webhook_url)
+			SELECT $2, user_id, offset_minutes, channel, webhook_url
+			FROM reminders
+			WHERE todo_id = $1
+				AND offset_minutes IS NOT NULL
+			ORDER BY id
+	`
+
+	_, err := tx.ExecContext(ctx, query, fromTodoID, toTodoID)
+	return err
+}
+
+// resetRelativeReminders puts the reminders of a todo which are relative to its due
+// date back in line after the due date has changed, so that they fire again for the
+// new one. Reminders being delivered right now are left alone.
+func resetRelativeReminders(ctx context.Context, tx *sql.Tx, todoID int64) error {
+
+	query := `
+		UPDATE reminders
+			SET status = $2, attempts = 0, sent_at = NULL, retry_at = NULL, claimed_at = NULL, error = ''
+			WHERE todo_id = $1
+				AND offset_minutes IS NOT NULL
+				AND status <> $3
+	`
+
+	_, err := tx.ExecContext(ctx, query, todoID, ReminderPending, ReminderSending)
+	return err
+}
+
+// ValidateReminder checks a reminder about todo.
+func ValidateReminder(v *validator.Validator, reminder *Reminder, todo *Todo) {
+
+	v.Check((reminder.RemindAt == nil) != (reminder.OffsetMinutes == nil), "remind_at", "must provide either remind_at or offset_minutes")
+
+	if reminder.RemindAt != nil {
+		v.Check(reminder.RemindAt.After(time.Now()), "remind_at", "must be in the future")
+	}
+
+	if reminder.OffsetMinutes != nil {
+		v.Check(todo.DueAt != nil, "offset_minutes", "requires the todo to have a due_at")
+		v.Check(*reminder.OffsetMinutes >= -366*24*60 && *reminder.OffsetMinutes <= 366*24*60, "offset_minutes", "must not be more than a year from the due date")
+	}
+
+	v.Check(validator.In(reminder.Channel, ReminderChannels...), "channel", "must be email, webhook or log")
+
+	if reminder.Channel == "webhook" {
+		if err := notify.ValidateWebhookURL(reminder.WebhookURL); err != nil {
+			v.AddError("webhook_url", err.Error())
+		}
+	} else {
+		v.Check(reminder.WebhookURL == "", "webhook_url", "must only be set for the webhook channel")
+	}
+}
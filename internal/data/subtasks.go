@@ -0,0 +1,180 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// MaxTodoDepth is how deep todos can nest: a todo, its subtasks, and the checklist
+// items of those.
+const MaxTodoDepth = 3
+
+var (
+	ErrTodoCycle   = errors.New("todo would become its own subtask")
+	ErrTodoTooDeep = errors.New("subtasks nested too deep")
+)
+
+// subtreeQuery is a common table expression holding the ids of every subtask of todo
+// $1 of user $2 which isn't in the trash, at any depth.
+const subtreeQuery = `
+	WITH RECURSIVE subtree AS (
+		SELECT id FROM todo WHERE parent_id = $1 AND user_id = $2 AND deleted_at IS NULL
+		UNION ALL
+		SELECT todo.id FROM todo INNER JOIN subtree ON todo.parent_id = subtree.id
+		WHERE todo.deleted_at IS NULL
+	)`
+
+// CheckParent checks that the todo with id todoID (0 for a new todo) can be moved
+// under parentID: the parent has to belong to the user, can't be in the todo's own
+// subtree, and the nesting can't get deeper than MaxTodoDepth.
+func (m *TodoModel) CheckParent(userID, todoID, parentID int64) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// walk up from the parent to the top-level todo
+	query := `
+		WITH RECURSIVE ancestors AS (
+			SELECT id, parent_id, 1 AS depth FROM todo WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+			UNION ALL
+			SELECT todo.id, todo.parent_id, ancestors.depth + 1
+			FROM todo INNER JOIN ancestors ON todo.id = ancestors.parent_id
+		)
+		SELECT coalesce(max(depth), 0), coalesce(bool_or(id = $3), false) FROM ancestors
+	`
+
+	var depth int
+	var cycle bool
+
+	err := m.DB.QueryRowContext(ctx, query, parentID, userID, todoID).Scan(&depth, &cycle)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case depth == 0:
+		return ErrRecordNotFound
+	case cycle:
+		return ErrTodoCycle
+	}
+
+	// the levels of subtasks which move along with the todo
+	height := 1
+	if todoID != 0 {
+		query = `
+			WITH RECURSIVE subtree AS (
+				SELECT id, 1 AS height FROM todo WHERE id = $1
+				UNION ALL
+				SELECT todo.id, subtree.height + 1
+				FROM todo INNER JOIN subtree ON todo.parent_id = subtree.id
+				WHERE todo.deleted_at IS NULL
+			)
+			SELECT max(height) FROM subtree
+		`
+		err = m.DB.QueryRowContext(ctx, query, todoID).Scan(&height)
+		if err != nil {
+			return err
+		}
+	}
+
+	if depth+height > MaxTodoDepth {
+		return ErrTodoTooDeep
+	}
+	return nil
+}
+
+// GetSubtree returns a todo with all of its subtasks filled in, at every depth.
+func (m *TodoModel) GetSubtree(id, userID int64) (*Todo, error) {
+
+	root, err := m.Get(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := subtreeQuery + `
+		SELECT ` + todoColumns + `
+		FROM todo
+		WHERE id IN (SELECT id FROM subtree)
+		ORDER BY creation_time, id
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, id, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byID := map[int64]*Todo{root.ID: root}
+	subtasks := []*Todo{}
+
+	for rows.Next() {
+		todo, err := scanTodo(rows)
+		if err != nil {
+			return nil, err
+		}
+		byID[todo.ID] = todo
+		subtasks = append(subtasks, todo)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// every parent is in the map, as the subtree is closed under parent_id
+	root.Subtasks = []*Todo{}
+	for _, todo := range subtasks {
+		todo.Subtasks = []*Todo{}
+	}
+	for _, todo := range subtasks {
+		parent := byID[*todo.ParentID]
+		parent.Subtasks = append(parent.Subtasks, todo)
+	}
+
+	err = attachTags(ctx, m.DB, subtasks...)
+	if err != nil {
+		return nil, err
+	}
+
+	return root, nil
+}
+
+// CountOpenSubtasks returns how many subtasks of the todo, at any depth, aren't
+// completed yet.
+func (m *TodoModel) CountOpenSubtasks(id, userID int64) (int, error) {
+
+	query := subtreeQuery + `
+		SELECT count(*) FROM todo
+		WHERE id IN (SELECT id FROM subtree)
+			AND NOT completed
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// completeSubtree completes every open subtask of the todo, at any depth.
+func completeSubtree(ctx context.Context, tx *sql.Tx, userID, id int64) error {
+
+	query := subtreeQuery + `
+		UPDATE todo
+			SET completed = true, completed_at = now(), version = version + 1
+		WHERE id IN (SELECT id FROM subtree)
+			AND NOT completed
+	`
+
+	_, err := tx.ExecContext(ctx, query, id, userID)
+	return err
+}
@@ -0,0 +1,313 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/ridwanulhoquejr/todo-app/internal/validator"
+)
+
+var (
+	ErrDuplicateTag = errors.New("duplicate tag")
+)
+
+// DefaultColor is the color of tags and projects created without one, such as the
+// tags which are created on the fly by naming them on a todo.
+const DefaultColor = "#808080"
+
+// Tag is a label which a user can put on any number of their todos. Names are unique
+// per user, ignoring case.
+type Tag struct {
+	ID           int64     `json:"id"`
+	UserID       int64     `json:"-"`
+	Name         string    `json:"name"`
+	Color        string    `json:"color"`
+	Version      int32     `json:"version"`
+	CreationTime time.Time `json:"creation_time"`
+}
+
+type TagModel struct {
+	DB *sql.DB
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func (m *TagModel) Insert(tag *Tag) error {
+
+	query := `
+		INSERT INTO tags (user_id, name, color)
+			VALUES ($1, $2, $3)
+		RETURNING id, version, creation_time
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tag.UserID, tag.Name, tag.Color).Scan(
+		&tag.ID,
+		&tag.Version,
+		&tag.CreationTime,
+	)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "tags_user_id_name_key"`:
+			return ErrDuplicateTag
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *TagModel) Get(id, userID int64) (*Tag, error) {
+
+	query := `
+		SELECT id, user_id, name, color, version, creation_time
+		FROM tags
+		WHERE id = $1
+			AND user_id = $2
+	`
+
+	var tag Tag
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(
+		&tag.ID,
+		&tag.UserID,
+		&tag.Name,
+		&tag.Color,
+		&tag.Version,
+		&tag.CreationTime,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &tag, nil
+}
+
+// GetAllForUser returns all of the user's tags, ordered by name.
+func (m *TagModel) GetAllForUser(userID int64) ([]*Tag, error) {
+
+	query := `
+		SELECT id, user_id, name, color, version, creation_time
+		FROM tags
+		WHERE user_id = $1
+		ORDER BY name
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTags(rows)
+}
+
+// Update renames or recolors a tag, with the same version check as TodoModel.Update.
+func (m *TagModel) Update(tag *Tag) error {
+
+	query := `
+		UPDATE tags
+			SET name = $1, color = $2, version = version + 1
+			WHERE id = $3
+				AND user_id = $4
+				AND version = $5
+		RETURNING version
+	`
+	args := []any{tag.Name, tag.Color, tag.ID, tag.UserID, tag.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&tag.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "tags_user_id_name_key"`:
+			return ErrDuplicateTag
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes a tag, and with it (through the foreign key) the tag from every todo.
+func (m *TagModel) Delete(id, userID int64) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM tags WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// setTodoTags replaces the tags of a todo with the named ones, creating the tags the
+// user doesn't have yet. The tags are filled in with what's stored.
+func setTodoTags(ctx context.Context, tx *sql.Tx, userID, todoID int64, tags []*Tag) ([]*Tag, error) {
+
+	names := tagNames(tags)
+
+	query := `
+		INSERT INTO tags (user_id, name, color)
+			SELECT $1, unnest($2::citext[]), $3
+		ON CONFLICT (user_id, name) DO NOTHING
+	`
+	_, err := tx.ExecContext(ctx, query, userID, pq.Array(names), DefaultColor)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM todo_tags WHERE todo_id = $1`, todoID)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		INSERT INTO todo_tags (todo_id, tag_id)
+			SELECT $1, id FROM tags WHERE user_id = $2 AND name = ANY($3::citext[])
+	`
+	_, err = tx.ExecContext(ctx, query, todoID, userID, pq.Array(names))
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT id, user_id, name, color, version, creation_time
+		FROM tags
+		WHERE user_id = $1
+			AND name = ANY($2::citext[])
+		ORDER BY name
+	`
+	rows, err := tx.QueryContext(ctx, query, userID, pq.Array(names))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTags(rows)
+}
+
+// attachTags loads the tags of all the given todos in one query, rather than one per
+// todo.
+func attachTags(ctx context.Context, db queryer, todos ...*Todo) error {
+
+	ids := make([]int64, len(todos))
+	byID := make(map[int64]*Todo, len(todos))
+	for i, todo := range todos {
+		ids[i] = todo.ID
+		byID[todo.ID] = todo
+		todo.Tags = []*Tag{}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query := `
+		SELECT todo_tags.todo_id, tags.id, tags.user_id, tags.name, tags.color, tags.version, tags.creation_time
+		FROM todo_tags
+			INNER JOIN tags ON tags.id = todo_tags.tag_id
+		WHERE todo_tags.todo_id = ANY($1)
+		ORDER BY tags.name
+	`
+
+	rows, err := db.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var todoID int64
+		var tag Tag
+
+		err := rows.Scan(&todoID, &tag.ID, &tag.UserID, &tag.Name, &tag.Color, &tag.Version, &tag.CreationTime)
+		if err != nil {
+			return err
+		}
+		byID[todoID].Tags = append(byID[todoID].Tags, &tag)
+	}
+
+	return rows.Err()
+}
+
+func scanTags(rows *sql.Rows) ([]*Tag, error) {
+
+	tags := []*Tag{}
+
+	for rows.Next() {
+		var tag Tag
+
+		err := rows.Scan(&tag.ID, &tag.UserID, &tag.Name, &tag.Color, &tag.Version, &tag.CreationTime)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, &tag)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// tagNames returns the distinct names of the tags, ignoring case.
+func tagNames(tags []*Tag) []string {
+
+	seen := make(map[string]bool, len(tags))
+	names := []string{}
+
+	for _, tag := range tags {
+		key := strings.ToLower(tag.Name)
+		if !seen[key] {
+			seen[key] = true
+			names = append(names, tag.Name)
+		}
+	}
+	return names
+}
+
+// ValidateTagName checks a tag name. Commas aren't allowed as the tags filter takes a
+// comma separated list of names.
+func ValidateTagName(v *validator.Validator, key, name string) {
+	v.Check(strings.TrimSpace(name) != "", key, "must not be blank")
+	v.Check(len(name) <= 50, key, "must not be more than 50 characters long")
+	v.Check(!strings.Contains(name, ","), key, "must not contain commas")
+}
+
+func ValidateTag(v *validator.Validator, tag *Tag) {
+	ValidateTagName(v, "name", tag.Name)
+	v.Check(validator.Matches(tag.Color, validator.ColorRX), "color", "must be a hex color such as #1e90ff")
+}
@@ -10,39 +10,169 @@ import (
 	"github.com/ridwanulhoquejr/todo-app/internal/validator"
 )
 
+// Todo is a single item on a user's list. DueAt is nil for todos without a deadline;
+// for all-day todos it holds the start of the due day in the user's time zone.
+//
+// Recurring todos have a Recurrence; completing one creates the todo of the next
+// occurrence, which takes the recurrence over.
+//
+// CompletedAt is when the todo was last completed, nil while it's open. Archived todos
+// are put away, they're only listed when asked for.
+//
+// Deleted todos are kept in the trash, with their DeletedAt set, until they're
+// restored or purged. Todos in the trash are left out everywhere else.
+//
+// Todos with a ParentID are subtasks of another todo. Progress counts the direct
+// subtasks of a todo, it is nil when there are none. Subtasks is only filled in when
+// a whole subtree is loaded, by GetSubtree().
 type Todo struct {
-	Title        string    `json:"title"`
-	Descripton   string    `json:"description"`
-	ID           int64     `json:"id"`
-	UserID       int64     `json:"user_id"`
-	Completed    bool      `json:"completed"`
-	Version      int32     `json:"verison"`
-	CreationTime time.Time `json:"creation_time"`
+	Title        string      `json:"title"`
+	Descripton   string      `json:"description"`
+	ID           int64       `json:"id"`
+	UserID       int64       `json:"user_id"`
+	ParentID     *int64      `json:"parent_id"`
+	Completed    bool        `json:"completed"`
+	CompletedAt  *time.Time  `json:"completed_at"`
+	Archived     bool        `json:"archived"`
+	Priority     Priority    `json:"priority"`
+	ProjectID    *int64      `json:"project_id"`
+	DueAt        *time.Time  `json:"due_at"`
+	AllDay       bool        `json:"all_day"`
+	Tags         []*Tag      `json:"tags"`
+	Recurrence   *Recurrence `json:"recurrence"`
+	Progress     *Progress   `json:"progress,omitempty"`
+	Subtasks     []*Todo     `json:"subtasks,omitempty"`
+	Version      int32       `json:"verison"`
+	CreationTime time.Time   `json:"creation_time"`
+	DeletedAt    *time.Time  `json:"deleted_at,omitempty"`
+}
+
+// Progress tells how many of the subtasks of a todo are done, as in "3/5 done".
+type Progress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
 }
 
 type TodoModel struct {
 	DB *sql.DB
 }
 
+// todoColumns are the columns read by scanTodo(), in order. The last two count the
+// direct subtasks of the todo which aren't in the trash, and how many of those are
+// completed.
+const todoColumns = `
+	id, user_id, parent_id, title, description, completed, completed_at, archived,
+	priority, project_id, due_at, all_day, rrule, rrule_time_zone, creation_time, version, deleted_at,
+	(SELECT count(*) FROM todo AS sub WHERE sub.parent_id = todo.id AND sub.deleted_at IS NULL),
+	(SELECT count(*) FROM todo AS sub WHERE sub.parent_id = todo.id AND sub.deleted_at IS NULL AND sub.completed)`
+
+// scanner is satisfied by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanTodo reads a todo selected with todoColumns. Any columns selected before those
+// are scanned into extra.
+func scanTodo(row scanner, extra ...any) (*Todo, error) {
+
+	var todo Todo
+	var rule, timeZone sql.NullString
+	var total, done int
+
+	dest := append(extra,
+		&todo.ID,
+		&todo.UserID,
+		&todo.ParentID,
+		&todo.Title,
+		&todo.Descripton,
+		&todo.Completed,
+		&todo.CompletedAt,
+		&todo.Archived,
+		&todo.Priority,
+		&todo.ProjectID,
+		&todo.DueAt,
+		&todo.AllDay,
+		&rule,
+		&timeZone,
+		&todo.CreationTime,
+		&todo.Version,
+		&todo.DeletedAt,
+		&total,
+		&done,
+	)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	if rule.Valid {
+		todo.Recurrence = &Recurrence{RRule: rule.String, TimeZone: timeZone.String}
+	}
+	if total > 0 {
+		todo.Progress = &Progress{Done: done, Total: total}
+	}
+	return &todo, nil
+}
+
+// recurrenceArgs returns the rrule and rrule_time_zone query arguments, which are
+// NULL for todos that don't repeat.
+func (t *Todo) recurrenceArgs() (any, any) {
+	if t.Recurrence == nil {
+		return nil, nil
+	}
+	return t.Recurrence.RRule, t.Recurrence.TimeZone
+}
+
 // here Todo struct methods will communicate with the Database
 func (m *TodoModel) Insert(todo *Todo) error {
+
+	// create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the todo and its tags are inserted together
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertTodo(ctx, tx, todo)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertTodo(ctx context.Context, tx *sql.Tx, todo *Todo) error {
 	// 1. query
 	query :=
 		`
 		INSERT INTO 
-			todo (title, description, user_id)
-			values ($1, $2, $3)
+			todo (title, description, user_id, priority, due_at, all_day, project_id, parent_id, rrule, rrule_time_zone)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 		RETURNING
 			id, completed, version, creation_time
 		`
-	// 2. args
-	args := []any{todo.Title, todo.Descripton, todo.UserID}
+	rule, timeZone := todo.recurrenceArgs()
 
-	// 3. create a context
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	// 2. args
+	args := []any{
+		todo.Title,
+		todo.Descripton,
+		todo.UserID,
+		todo.Priority,
+		todo.DueAt,
+		todo.AllDay,
+		todo.ProjectID,
+		todo.ParentID,
+		rule,
+		timeZone,
+	}
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+	err := tx.QueryRowContext(ctx, query, args...).Scan(
 		&todo.ID,
 		&todo.Completed,
 		&todo.Version,
@@ -52,7 +182,8 @@ func (m *TodoModel) Insert(todo *Todo) error {
 		return err
 	}
 
-	return nil
+	todo.Tags, err = setTodoTags(ctx, tx, todo.UserID, todo.ID, todo.Tags)
+	return err
 }
 
 func (m *TodoModel) Get(id, userId int64) (*Todo, error) {
@@ -60,28 +191,20 @@ func (m *TodoModel) Get(id, userId int64) (*Todo, error) {
 	// 1. write the query
 	query :=
 		`
-	SELECT id, title, description, completed, creation_time, version 
+	SELECT ` + todoColumns + `
 	FROM todo
 		WHERE id = $1 
 			AND user_id = $2
+			AND deleted_at IS NULL
 	ORDER BY creation_time DESC
 	LIMIT 1
 	`
 	args := []any{id, userId}
 
-	var todo Todo
-
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
-		&todo.ID,
-		&todo.Title,
-		&todo.Descripton,
-		&todo.Completed,
-		&todo.CreationTime,
-		&todo.Version,
-	)
+	todo, err := scanTodo(m.DB.QueryRowContext(ctx, query, args...))
 
 	if err != nil {
 		switch {
@@ -92,24 +215,44 @@ func (m *TodoModel) Get(id, userId int64) (*Todo, error) {
 		}
 	}
 
-	return &todo, nil
+	err = attachTags(ctx, m.DB, todo)
+	if err != nil {
+		return nil, err
+	}
+
+	return todo, nil
 }
 
+// GetAll returns a page of the user's todos. All the criteria of the queries go
+// through one filter expression, which is compiled into the WHERE clause. Filters on
+// dates work on calendar days in the user's time zone, which is why the users row is
+// joined in.
 func (m *TodoModel) GetAll(userId int64, q Queries) ([]*Todo, Metadata, error) {
+
+	// $1 is the user, the arguments of the filter follow
+	c := &filterCompiler{args: []any{userId}}
+
+	where, err := c.compile(q.expr())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
 	query :=
 		fmt.Sprintf(`
 				SELECT
-					 count(*) OVER(), id, user_id, title, description, completed, creation_time, version
-				FROM todo
+					 count(*) OVER(), %s
+				FROM todo,
+					(SELECT time_zone FROM users WHERE id = $1) AS settings
 					WHERE
 						user_id = $1
-						AND (to_tsvector('simple', title) @@ plainto_tsquery('simple', $2) OR $2 = '')
-						AND (creation_time BETWEEN $3 AND $4)
+						AND deleted_at IS NULL
+						AND %s
 				ORDER BY %s %s, id ASC
-				LIMIT $5 OFFSET $6`,
-			q.Sorts.sortColumn(), q.Sorts.sortDirection())
+				LIMIT %s OFFSET %s`,
+			todoColumns, where, q.Sorts.sortColumn(), q.Sorts.sortDirection(),
+			c.arg(q.Pagination.limit()), c.arg(q.Pagination.offset()))
 
-	args := []any{userId, q.Search.Title, q.Filters.StartDate, q.Filters.EndDate, q.Pagination.limit(), q.Pagination.offset()}
+	args := c.args
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -125,22 +268,12 @@ func (m *TodoModel) GetAll(userId int64, q Queries) ([]*Todo, Metadata, error) {
 
 	for rows.Next() {
 
-		var todo Todo
-		err := rows.Scan(
-			&totalRecords,
-			&todo.ID,
-			&todo.UserID,
-			&todo.Title,
-			&todo.Descripton,
-			&todo.Completed,
-			&todo.CreationTime,
-			&todo.Version,
-		)
+		todo, err := scanTodo(rows, &totalRecords)
 		// handle the Scan err
 		if err != nil {
 			return nil, Metadata{}, err
 		}
-		todos = append(todos, &todo)
+		todos = append(todos, todo)
 	}
 	// When the rows.Next() loop has finished, call rows.Err() to retrieve any error
 	// that was encountered during the iteration.
@@ -148,48 +281,168 @@ func (m *TodoModel) GetAll(userId int64, q Queries) ([]*Todo, Metadata, error) {
 		return nil, Metadata{}, nil
 	}
 
+	err = attachTags(ctx, m.DB, todos...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
 	// calculate the metadata by calling the metdata method
 	metadata := calculateMetadata(totalRecords, q.Pagination.Page, q.Pagination.PageSize)
 	return todos, metadata, nil
 }
 
 func (m *TodoModel) Update(userID int64, todo *Todo) error {
+	_, err := m.update(userID, todo, false, false)
+	return err
+}
+
+// Complete saves a todo which has just been completed. With completeSubtasks all of
+// its subtasks, at any depth, are completed along with it. If the todo is recurring,
+// the todo of its next occurrence is created, with the reminders relative to the due
+// date, and returned; nil is returned for the last occurrence of a series. It all
+// happens in one transaction.
+func (m *TodoModel) Complete(userID int64, todo *Todo, completeSubtasks bool) (*Todo, error) {
+	return m.update(userID, todo, true, completeSubtasks)
+}
+
+func (m *TodoModel) update(userID int64, todo *Todo, completing, completeSubtasks bool) (*Todo, error) {
+
+	// the series moves on to the next occurrence, and this todo stops repeating
+	var next *Todo
+	if completing && todo.Recurrence != nil {
+		var err error
+		next, err = todo.NextOccurrence()
+		if err != nil && !errors.Is(err, ErrSeriesEnded) {
+			return nil, err
+		}
+		todo.Recurrence = nil
+	}
+
 	query := `
 		UPDATE todo
 			SET
 				title = $1,
 				description = $2,
 				completed = $3,
+				completed_at = CASE WHEN $3 THEN coalesce(completed_at, now()) END,
+				priority = $4,
+				due_at = $5,
+				all_day = $6,
+				project_id = $7,
+				parent_id = $8,
+				rrule = $9,
+				rrule_time_zone = $10,
 				version = version +1
-			WHERE id = $4
-				AND user_id = $5
-				AND version = $6
-		RETURNING version
+			WHERE id = $11
+				AND user_id = $12
+				AND version = $13
+				AND deleted_at IS NULL
+		RETURNING version, completed_at,
+			-- the subquery sees the row as it was before the update
+			(SELECT old.due_at FROM todo AS old WHERE old.id = todo.id) IS DISTINCT FROM todo.due_at
 	`
-	args := []any{todo.Title, todo.Descripton, todo.Completed, todo.ID, userID, todo.Version}
+	rule, timeZone := todo.recurrenceArgs()
+
+	args := []any{
+		todo.Title,
+		todo.Descripton,
+		todo.Completed,
+		todo.Priority,
+		todo.DueAt,
+		todo.AllDay,
+		todo.ProjectID,
+		todo.ParentID,
+		rule,
+		timeZone,
+		todo.ID,
+		userID,
+		todo.Version,
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&todo.Version)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var rescheduled bool
+
+	// completed_at follows completed, keeping the original time while it stays completed
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&todo.Version, &todo.CompletedAt, &rescheduled)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrRecordNotFound
+			return nil, ErrEditConflict
 		default:
-			return err
+			return nil, err
 		}
 	}
-	return nil
+
+	// Tags holds the full set of tags, the ones loaded by Get() if they weren't changed
+	todo.Tags, err = setTodoTags(ctx, tx, userID, todo.ID, todo.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	// reminders relative to the due date follow it, even the ones which have fired
+	if rescheduled {
+		err = resetRelativeReminders(ctx, tx, todo.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if completeSubtasks {
+		err = completeSubtree(ctx, tx, userID, todo.ID)
+		if err != nil {
+			return nil, err
+		}
+		if todo.Progress != nil {
+			todo.Progress.Done = todo.Progress.Total
+		}
+	}
+
+	if next != nil {
+		err = insertTodo(ctx, tx, next)
+		if err != nil {
+			return nil, err
+		}
+
+		err = copyReminders(ctx, tx, todo.ID, next.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return next, nil
 }
 
+// Delete moves a todo, along with its subtasks, to the trash. They all get the same
+// deleted_at, which is how Restore() knows to bring them back together.
 func (m *TodoModel) Delete(id, userID int64) error {
 
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	query := `DELETE from todo WHERE id = $1 and user_id = $2`
+	query := `
+		WITH RECURSIVE subtree AS (
+			SELECT id FROM todo WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+			UNION ALL
+			SELECT todo.id FROM todo INNER JOIN subtree ON todo.parent_id = subtree.id
+			WHERE todo.deleted_at IS NULL
+		)
+		UPDATE todo
+			SET deleted_at = now(), version = version + 1
+			WHERE id IN (SELECT id FROM subtree)
+	`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -213,4 +466,22 @@ func (m *TodoModel) Delete(id, userID int64) error {
 func ValidateTodo(v *validator.Validator, todo *Todo) {
 	v.Check(todo.Title != "", "title", "must be provided")
 	v.Check(todo.UserID != 0, "user_id", "must be provided")
+	ValidatePriority(v, todo.Priority)
+	ValidateDueDate(v, todo)
+	ValidateTodoTags(v, todo)
+	ValidateRecurrence(v, todo)
+}
+
+// ValidateDueDate checks the due date fields of a todo, for updates which don't go
+// through ValidateTodo.
+func ValidateDueDate(v *validator.Validator, todo *Todo) {
+	v.Check(!todo.AllDay || todo.DueAt != nil, "all_day", "requires due_at to be set")
+}
+
+// ValidateTodoTags checks the names of the tags on a todo.
+func ValidateTodoTags(v *validator.Validator, todo *Todo) {
+	v.Check(len(todo.Tags) <= 20, "tags", "must not contain more than 20 tags")
+	for _, tag := range todo.Tags {
+		ValidateTagName(v, "tags", tag.Name)
+	}
 }
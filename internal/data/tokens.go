@@ -6,8 +6,11 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/ridwanulhoquejr/todo-app/internal/validator"
 )
 
@@ -16,14 +19,88 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh"
+	ScopePersonalAccess = "personal-access"
+	ScopeImpersonation  = "impersonation"
+	ScopeMFAPending     = "mfa-pending"
+	ScopeOAuthAccess    = "oauth-access"
+	ScopeOAuthRefresh   = "oauth-refresh"
+	ScopeEmailChange    = "email-change"
 )
 
+// PersonalAccessTokenPrefix is prepended to the plaintext of personal access tokens,
+// so that they are easy to recognise, both by authenticate and by secret scanners.
+// Impersonation and OAuth tokens get their own prefixes for the same reason.
+const (
+	PersonalAccessTokenPrefix = "tdp_"
+	ImpersonationTokenPrefix  = "tdi_"
+	OAuthAccessTokenPrefix    = "tdo_"
+	OAuthRefreshTokenPrefix   = "tdr_"
+)
+
+// APIScopes lists every scope a personal access token can be granted. Scopes use the
+// same codes as permissions: a scoped token may only do what both its scopes and
+// the permissions of its user allow.
+var APIScopes = []string{PermissionTodosRead, PermissionTodosWrite}
+
+// ErrTokenReused is returned by Rotate() when a refresh token which has already been
+// exchanged is presented again. This means the token has most likely leaked, so the
+// whole token family has been revoked by the time the caller sees this error.
+var ErrTokenReused = errors.New("refresh token reused")
+
 type Token struct {
-	Plaintext string    `json:"token"`
-	Hash      []byte    `json:"-"`
-	UserId    int64     `json:"-"`
-	Expiry    time.Time `json:"expiry"`
-	Scope     string    `json:"-"`
+	ID           int64      `json:"-"`
+	Plaintext    string     `json:"token"`
+	Hash         []byte     `json:"-"`
+	UserId       int64      `json:"-"`
+	Expiry       time.Time  `json:"expiry"`
+	Scope        string     `json:"-"`
+	CreationTime time.Time  `json:"-"`
+	LastUsedAt   *time.Time `json:"-"`
+	UserAgent    string     `json:"-"`
+	IPAddress    string     `json:"-"`
+	Family       string     `json:"-"`
+	RotatedAt    *time.Time `json:"-"`
+	Name         string     `json:"-"`
+	APIScopes    []string   `json:"-"`
+	// ImpersonatorID is the admin an impersonation token was issued to
+	ImpersonatorID int64 `json:"-"`
+	// ClientID is the OAuth client an OAuth token was issued to
+	ClientID string `json:"-"`
+}
+
+// PersonalAccessToken is the client facing view of a personal access token. The
+// plaintext is only ever set on the response to its creation.
+type PersonalAccessToken struct {
+	ID           int64      `json:"id"`
+	Name         string     `json:"name"`
+	Plaintext    string     `json:"token,omitempty"`
+	Scopes       []string   `json:"scopes"`
+	Expiry       time.Time  `json:"expiry"`
+	CreationTime time.Time  `json:"creation_time"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+}
+
+// TokenPair is what a successful login hands back to the client: a short-lived access
+// token for the Authorization header and a long-lived refresh token to get new ones.
+// Both tokens share the same family, which identifies the login session.
+type TokenPair struct {
+	AccessToken  *Token `json:"access_token"`
+	RefreshToken *Token `json:"refresh_token"`
+}
+
+// Session is the client facing view of a token family. It never carries the tokens
+// themselves, only enough information for a user to recognise where they are
+// logged in.
+type Session struct {
+	ID           string     `json:"id"`
+	CreationTime time.Time  `json:"creation_time"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+	Expiry       time.Time  `json:"expiry"`
+	UserAgent    string     `json:"user_agent"`
+	IPAddress    string     `json:"ip_address"`
+	Current      bool       `json:"current"`
 }
 
 // db model
@@ -31,6 +108,12 @@ type TokenModel struct {
 	DB *sql.DB
 }
 
+// queryRower is satisfied by both *sql.DB and *sql.Tx, so that the insert query can be
+// shared between standalone inserts and the ones done inside a transaction.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 func generateToken(userId int64, ttl time.Duration, scope string) (*Token, error) {
 
 	// create a Token struct
@@ -40,19 +123,11 @@ func generateToken(userId int64, ttl time.Duration, scope string) (*Token, error
 		Scope:  scope,
 	}
 
-	// Initialize a zero-valued byte slice with a length of 16 bytes.
-	randomBytes := make([]byte, 16)
-
-	// Use the Read() function from the crypto/rand package to fill the byte slice with
-	// random bytes from your operating system's CSPRNG. This will return an error if
-	// the CSPRNG fails to function correctly.
-	_, err := rand.Read(randomBytes)
+	plaintext, err := randomString()
 	if err != nil {
 		return nil, err
 	}
-
-	// encoded 16 chanracter string
-	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	token.Plaintext = plaintext
 
 	// Generate a SHA-256 hash of the plaintext token string. This will be the value
 	// that we store in the `hash` field of our database table. Note that the
@@ -64,12 +139,52 @@ func generateToken(userId int64, ttl time.Duration, scope string) (*Token, error
 	return token, nil
 }
 
+// randomString returns 16 random bytes, base32 encoded into a 26 character string.
+func randomString() (string, error) {
+
+	// Initialize a zero-valued byte slice with a length of 16 bytes.
+	randomBytes := make([]byte, 16)
+
+	// Use the Read() function from the crypto/rand package to fill the byte slice with
+	// random bytes from your operating system's CSPRNG. This will return an error if
+	// the CSPRNG fails to function correctly.
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	// encoded 16 chanracter string
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes), nil
+}
+
 // Check that the plaintext token has been provided and is exactly 52 bytes long.
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(tokenPlaintext != "", "token", "must be provided")
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
 }
 
+// ValidatePrefixedTokenPlaintext checks the prefix and length of a personal access or
+// impersonation token.
+func ValidatePrefixedTokenPlaintext(v *validator.Validator, tokenPlaintext, prefix string) {
+	v.Check(strings.HasPrefix(tokenPlaintext, prefix), "token", "must start with "+prefix)
+	v.Check(len(tokenPlaintext) == len(prefix)+26, "token", "must be 30 bytes long")
+}
+
+func ValidatePersonalAccessToken(v *validator.Validator, name string, expiresInDays int, scopes []string) {
+
+	v.Check(name != "", "name", "must be provided")
+	v.Check(len(name) <= 100, "name", "must not be more than 100 characters long")
+
+	v.Check(expiresInDays >= 1, "expires_in_days", "must be at least 1")
+	v.Check(expiresInDays <= 365, "expires_in_days", "must not be more than 365")
+
+	v.Check(len(scopes) > 0, "scopes", "must contain at least 1 scope")
+	v.Check(validator.Unique(scopes), "scopes", "must not contain duplicate values")
+	for _, scope := range scopes {
+		v.Check(validator.In(scope, APIScopes...), "scopes", "must only contain known scopes")
+	}
+}
+
 // The New() method is a shortcut which creates a new Token struct and then inserts the
 // data in the tokens table
 func (m *TokenModel) New(userId int64, ttl time.Duration, scope string) (*Token, error) {
@@ -87,27 +202,725 @@ func (m *TokenModel) New(userId int64, ttl time.Duration, scope string) (*Token,
 	return token, nil
 }
 
+// NewPair creates an access token and a refresh token belonging to a brand new token
+// family, and inserts both of them in a single transaction. The client the pair was
+// issued to is recorded as well, as it is shown back to the user in their sessions.
+//
+// A zero accessTTL skips the access token, for callers which issue self-contained
+// access tokens instead; only RefreshToken is set on the returned pair then.
+func (m *TokenModel) NewPair(userId int64, accessTTL, refreshTTL time.Duration, userAgent, ipAddress string) (*TokenPair, error) {
+
+	family, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	pair, err := insertPair(ctx, tx, userId, family, accessTTL, refreshTTL, userAgent, ipAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return pair, nil
+}
+
+// Rotate exchanges a refresh token for a new token pair in the same family. The old
+// refresh token is marked as rotated rather than deleted, so that if it is ever
+// presented again we can tell that it has been reused and revoke the whole family.
+func (m *TokenModel) Rotate(refreshPlaintext string, accessTTL, refreshTTL time.Duration, userAgent, ipAddress string) (*TokenPair, error) {
+
+	tokenHash := sha256.Sum256([]byte(refreshPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// lock the refresh token row, so that two concurrent refreshes with the same
+	// token can't both succeed
+	query := `
+		SELECT user_id, family, expiry, rotated_at
+		FROM token
+			WHERE hash = $1
+				AND scope = $2
+		FOR UPDATE
+	`
+
+	var token Token
+	var family sql.NullString
+
+	err = tx.QueryRowContext(ctx, query, tokenHash[:], ScopeRefresh).Scan(
+		&token.UserId,
+		&family,
+		&token.Expiry,
+		&token.RotatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if !family.Valid || token.Expiry.Before(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+	token.Family = family.String
+
+	// the token has been exchanged before: revoke the whole family
+	if token.RotatedAt != nil {
+		_, err = tx.ExecContext(ctx, `DELETE FROM token WHERE family = $1`, token.Family)
+		if err != nil {
+			return nil, err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrTokenReused
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE token SET rotated_at = now() WHERE hash = $1`, tokenHash[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// the access tokens issued with the old refresh token are not needed anymore
+	query = `
+		DELETE FROM token
+		WHERE family = $1 AND scope = $2
+	`
+	_, err = tx.ExecContext(ctx, query, token.Family, ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+
+	pair, err := insertPair(ctx, tx, token.UserId, token.Family, accessTTL, refreshTTL, userAgent, ipAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return pair, nil
+}
+
+// insertPair generates and inserts an access and a refresh token in the given family.
+// The access token is left out if accessTTL is zero.
+func insertPair(ctx context.Context, q queryRower, userId int64, family string, accessTTL, refreshTTL time.Duration, userAgent, ipAddress string) (*TokenPair, error) {
+
+	pair := &TokenPair{}
+
+	tokens := []struct {
+		dst   **Token
+		ttl   time.Duration
+		scope string
+	}{
+		{&pair.AccessToken, accessTTL, ScopeAuthentication},
+		{&pair.RefreshToken, refreshTTL, ScopeRefresh},
+	}
+
+	for _, t := range tokens {
+		if t.ttl == 0 {
+			continue
+		}
+
+		token, err := generateToken(userId, t.ttl, t.scope)
+		if err != nil {
+			return nil, err
+		}
+		token.Family = family
+		token.UserAgent = userAgent
+		token.IPAddress = ipAddress
+
+		err = insertToken(ctx, q, token)
+		if err != nil {
+			return nil, err
+		}
+		*t.dst = token
+	}
+
+	return pair, nil
+}
+
+// NewPersonalAccess creates a named, scoped token for use by scripts and other
+// non-interactive clients. Its plaintext carries the PersonalAccessTokenPrefix.
+func (m *TokenModel) NewPersonalAccess(userId int64, name string, ttl time.Duration, scopes []string) (*PersonalAccessToken, error) {
+
+	token, err := generatePrefixedToken(userId, ttl, ScopePersonalAccess, PersonalAccessTokenPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Name = name
+	token.APIScopes = scopes
+
+	err = m.Insert(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PersonalAccessToken{
+		ID:           token.ID,
+		Name:         token.Name,
+		Plaintext:    token.Plaintext,
+		Scopes:       token.APIScopes,
+		Expiry:       token.Expiry,
+		CreationTime: token.CreationTime,
+	}, nil
+}
+
+// NewImpersonation creates a token which lets an admin act as another user. It is
+// restricted to the given API scopes, and the admin is recorded on the token.
+func (m *TokenModel) NewImpersonation(userId, impersonatorId int64, ttl time.Duration, scopes []string) (*Token, error) {
+
+	token, err := generatePrefixedToken(userId, ttl, ScopeImpersonation, ImpersonationTokenPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	token.APIScopes = scopes
+	token.ImpersonatorID = impersonatorId
+
+	err = m.Insert(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// NewOAuthPair issues the access and refresh token of an OAuth grant, both limited
+// to the scopes the user consented to. The pair gets a family of its own, so that
+// revoking either token ends the whole grant.
+func (m *TokenModel) NewOAuthPair(userId int64, clientID string, scopes []string, accessTTL, refreshTTL time.Duration) (*TokenPair, error) {
+
+	family, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	pair, err := insertOAuthPair(ctx, tx, userId, clientID, family, scopes, accessTTL, refreshTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return pair, nil
+}
+
+// RotateOAuth exchanges an OAuth refresh token of the client for a new pair of the
+// same grant. The old refresh token is deleted, so it can only be used once.
+func (m *TokenModel) RotateOAuth(refreshPlaintext, clientID string, accessTTL, refreshTTL time.Duration) (*TokenPair, error) {
+
+	tokenHash := sha256.Sum256([]byte(refreshPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		DELETE FROM token
+			WHERE hash = $1
+				AND scope = $2
+				AND client_id = $3
+				AND expiry > $4
+		RETURNING user_id, family, api_scopes
+	`
+	args := []any{tokenHash[:], ScopeOAuthRefresh, clientID, time.Now()}
+
+	var userId int64
+	var family string
+	var scopes []string
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&userId, &family, pq.Array(&scopes))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	pair, err := insertOAuthPair(ctx, tx, userId, clientID, family, scopes, accessTTL, refreshTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return pair, nil
+}
+
+func insertOAuthPair(ctx context.Context, q queryRower, userId int64, clientID, family string, scopes []string, accessTTL, refreshTTL time.Duration) (*TokenPair, error) {
+
+	pair := &TokenPair{}
+
+	tokens := []struct {
+		dst    **Token
+		ttl    time.Duration
+		scope  string
+		prefix string
+	}{
+		{&pair.AccessToken, accessTTL, ScopeOAuthAccess, OAuthAccessTokenPrefix},
+		{&pair.RefreshToken, refreshTTL, ScopeOAuthRefresh, OAuthRefreshTokenPrefix},
+	}
+
+	for _, t := range tokens {
+		token, err := generatePrefixedToken(userId, t.ttl, t.scope, t.prefix)
+		if err != nil {
+			return nil, err
+		}
+		token.Family = family
+		token.ClientID = clientID
+		token.APIScopes = scopes
+
+		err = insertToken(ctx, q, token)
+		if err != nil {
+			return nil, err
+		}
+		*t.dst = token
+	}
+
+	return pair, nil
+}
+
+// RevokeOAuth deletes the grant the token belongs to, if the token was issued to the
+// client. ErrRecordNotFound is returned otherwise.
+func (m *TokenModel) RevokeOAuth(tokenPlaintext, clientID string) error {
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		DELETE FROM token
+			WHERE family IN (
+				SELECT family FROM token
+					WHERE hash = $1 AND client_id = $2 AND scope IN ($3, $4)
+			)
+	`
+	args := []any{tokenHash[:], clientID, ScopeOAuthAccess, ScopeOAuthRefresh}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// generatePrefixedToken is like generateToken, with the prefix prepended to the
+// plaintext. The prefix is part of the token, so the hash covers it as well.
+func generatePrefixedToken(userId int64, ttl time.Duration, scope, prefix string) (*Token, error) {
+
+	token, err := generateToken(userId, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Plaintext = prefix + token.Plaintext
+	hash := sha256.Sum256([]byte(token.Plaintext))
+	token.Hash = hash[:]
+
+	return token, nil
+}
+
 func (m *TokenModel) Insert(token *Token) error {
 
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return insertToken(ctx, m.DB, token)
+}
+
+func insertToken(ctx context.Context, q queryRower, token *Token) error {
+
 	query :=
 		`INSERT INTO TOKEN 
-			(hash, user_id, expiry, scope)
+			(hash, user_id, expiry, scope, user_agent, ip_address, family, name, api_scopes, impersonator_id, client_id)
 		VALUES 
-			($1, $2, $3, $4)
+			($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8, COALESCE($9, '{}'), NULLIF($10, 0), NULLIF($11, ''))
+		RETURNING
+			id, creation_time
 	`
 
-	args := []any{token.Hash, token.UserId, token.Expiry, token.Scope}
+	args := []any{
+		token.Hash,
+		token.UserId,
+		token.Expiry,
+		token.Scope,
+		token.UserAgent,
+		token.IPAddress,
+		token.Family,
+		token.Name,
+		pq.Array(token.APIScopes),
+		token.ImpersonatorID,
+		token.ClientID,
+	}
+
+	err := q.QueryRowContext(ctx, query, args...).Scan(&token.ID, &token.CreationTime)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Get returns the unexpired token with the given scope matching the plaintext.
+func (m *TokenModel) Get(scope, tokenPlaintext string) (*Token, error) {
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT
+			id, user_id, expiry, scope, creation_time, last_used_at, user_agent, ip_address,
+			COALESCE(family, ''), rotated_at, name, api_scopes, COALESCE(impersonator_id, 0),
+			COALESCE(client_id, '')
+		FROM token
+			WHERE hash = $1
+				AND scope = $2
+				AND expiry > $3
+	`
+	args := []any{tokenHash[:], scope, time.Now()}
+
+	token := Token{
+		Plaintext: tokenPlaintext,
+		Hash:      tokenHash[:],
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&token.ID,
+		&token.UserId,
+		&token.Expiry,
+		&token.Scope,
+		&token.CreationTime,
+		&token.LastUsedAt,
+		&token.UserAgent,
+		&token.IPAddress,
+		&token.Family,
+		&token.RotatedAt,
+		&token.Name,
+		pq.Array(&token.APIScopes),
+		&token.ImpersonatorID,
+		&token.ClientID,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &token, nil
+}
+
+// Delete removes the token with the given scope matching the plaintext.
+func (m *TokenModel) Delete(scope, tokenPlaintext string) error {
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		DELETE FROM token
+		WHERE hash = $1 AND scope = $2
+	`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	result, err := m.DB.ExecContext(ctx, query, tokenHash[:], scope)
 	if err != nil {
 		return err
 	}
 
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
 	return nil
 }
 
-func (m *TokenModel) Get()    {}
-func (m *TokenModel) Delete() {}
+// DeleteFamily deletes the token matching the plaintext together with every other
+// token of the same family, which ends the whole login session.
+func (m *TokenModel) DeleteFamily(scope, tokenPlaintext string) error {
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	// tokens issued before families existed have none, so also match the token itself
+	query := `
+		DELETE FROM token
+		WHERE (hash = $1 AND scope = $2)
+			OR family = (SELECT family FROM token WHERE hash = $1 AND scope = $2)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, tokenHash[:], scope)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetPersonalAccessForUser lists the unexpired personal access tokens of a user.
+func (m *TokenModel) GetPersonalAccessForUser(userID int64) ([]*PersonalAccessToken, error) {
+
+	query := `
+		SELECT
+			id, name, api_scopes, expiry, creation_time, last_used_at
+		FROM token
+			WHERE user_id = $1
+				AND scope = $2
+				AND expiry > $3
+		ORDER BY creation_time DESC, id DESC
+	`
+	args := []any{userID, ScopePersonalAccess, time.Now()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*PersonalAccessToken{}
+
+	for rows.Next() {
+
+		var token PersonalAccessToken
+		err := rows.Scan(
+			&token.ID,
+			&token.Name,
+			pq.Array(&token.Scopes),
+			&token.Expiry,
+			&token.CreationTime,
+			&token.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, &token)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// DeletePersonalAccess revokes a personal access token of the user by its id.
+func (m *TokenModel) DeletePersonalAccess(id, userID int64) error {
+
+	query := `
+		DELETE FROM token
+		WHERE id = $1 AND user_id = $2 AND scope = $3
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID, ScopePersonalAccess)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteByFamily deletes every token of a family.
+func (m *TokenModel) DeleteByFamily(family string) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM token WHERE family = $1`, family)
+	return err
+}
+
+// DeleteAllForUser deletes all tokens for a specific user and scope.
+func (m *TokenModel) DeleteAllForUser(scope string, userID int64) error {
+
+	query := `
+		DELETE FROM token
+		WHERE scope = $1 AND user_id = $2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, scope, userID)
+	return err
+}
+
+// Touch records that a token has just been used, and by which client. To keep this
+// cheap on the hot path, the row is only written to at most once a minute.
+func (m *TokenModel) Touch(tokenPlaintext, userAgent, ipAddress string) error {
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		UPDATE token
+			SET
+				last_used_at = now(),
+				user_agent = $2,
+				ip_address = $3
+			WHERE hash = $1
+				AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, tokenHash[:], userAgent, ipAddress)
+	return err
+}
+
+// GetSessionsForUser lists the login sessions (token families) of a user which still
+// have a refresh token that can be exchanged. The session matching currentFamily
+// (the one the request was made with) is flagged as current.
+func (m *TokenModel) GetSessionsForUser(userID int64, currentFamily string) ([]*Session, error) {
+
+	// The user agent and IP address are taken from the most recent token of the family,
+	// as that is the one Touch() keeps up to date.
+	query := `
+		SELECT
+			family,
+			min(creation_time),
+			max(last_used_at),
+			max(expiry),
+			(array_agg(user_agent ORDER BY id DESC))[1],
+			(array_agg(ip_address ORDER BY id DESC))[1],
+			family = $2
+		FROM token
+			WHERE user_id = $1
+				AND family IS NOT NULL
+				AND expiry > $4
+		GROUP BY family
+		HAVING count(*) FILTER (WHERE scope = $3 AND rotated_at IS NULL) > 0
+		ORDER BY min(creation_time) DESC
+	`
+	args := []any{userID, currentFamily, ScopeRefresh, time.Now()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+
+		var session Session
+		err := rows.Scan(
+			&session.ID,
+			&session.CreationTime,
+			&session.LastUsedAt,
+			&session.Expiry,
+			&session.UserAgent,
+			&session.IPAddress,
+			&session.Current,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
@@ -0,0 +1,296 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ridwanulhoquejr/todo-app/internal/validator"
+)
+
+// recoveryCodeCount is how many recovery codes a user gets when enabling two-factor
+// authentication. Each of them can be used once in place of a TOTP code.
+const recoveryCodeCount = 10
+
+var (
+	// ErrTOTPEnabled is returned by Enroll() when the user already has two-factor
+	// authentication enabled; it must be disabled before enrolling again.
+	ErrTOTPEnabled = errors.New("two-factor authentication already enabled")
+	// ErrTOTPCodeReused is returned by UseStep() when a code of the same or a later
+	// time step has already been used.
+	ErrTOTPCodeReused = errors.New("totp code already used")
+)
+
+// TOTP is the two-factor authentication setup of a user. Secret is encrypted, it's
+// up to the caller to decrypt it.
+type TOTP struct {
+	UserID       int64
+	Secret       []byte
+	Enabled      bool
+	LastUsedStep int64
+	CreationTime time.Time
+	EnabledAt    *time.Time
+}
+
+type TOTPModel struct {
+	DB *sql.DB
+}
+
+// Get returns the TOTP setup of the user, enabled or not.
+func (m *TOTPModel) Get(userID int64) (*TOTP, error) {
+
+	query := `
+		SELECT user_id, secret, enabled, last_used_step, creation_time, enabled_at
+		FROM user_totp
+			WHERE user_id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var t TOTP
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&t.UserID,
+		&t.Secret,
+		&t.Enabled,
+		&t.LastUsedStep,
+		&t.CreationTime,
+		&t.EnabledAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &t, nil
+}
+
+// Enroll stores a new, not yet enabled, secret for the user, replacing any previous
+// enrollment which was never confirmed.
+func (m *TOTPModel) Enroll(userID int64, secret []byte) error {
+
+	query := `
+		INSERT INTO user_totp (user_id, secret)
+			VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+			SET secret = EXCLUDED.secret, last_used_step = 0, creation_time = now()
+			WHERE user_totp.enabled = false
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrTOTPEnabled
+	}
+
+	return nil
+}
+
+// Enable turns on two-factor authentication once the user proved their authenticator
+// works, recording the step of the code they used. Any old recovery codes are
+// replaced by the given ones.
+func (m *TOTPModel) Enable(userID, step int64, recoveryCodes []string) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	query := `
+		UPDATE user_totp
+			SET enabled = true, enabled_at = now(), last_used_step = $2
+			WHERE user_id = $1 AND enabled = false
+	`
+
+	result, err := tx.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range recoveryCodes {
+		hash := hashRecoveryCode(code)
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (user_id, hash) VALUES ($1, $2)`, userID, hash[:])
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UseStep records that the code of the given time step has been used. Codes of that
+// step or any earlier one are rejected from then on, so that an intercepted code
+// can't be replayed.
+func (m *TOTPModel) UseStep(userID, step int64) error {
+
+	query := `
+		UPDATE user_totp
+			SET last_used_step = $2
+			WHERE user_id = $1 AND last_used_step < $2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrTOTPCodeReused
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks one of the user's recovery codes as used. ErrRecordNotFound
+// is returned if the code is wrong or has been used already.
+func (m *TOTPModel) UseRecoveryCode(userID int64, code string) error {
+
+	hash := hashRecoveryCode(code)
+
+	query := `
+		UPDATE recovery_codes
+			SET used_at = now()
+			WHERE user_id = $1 AND hash = $2 AND used_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, hash[:])
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Delete disables two-factor authentication, removing the secret and the recovery
+// codes of the user.
+func (m *TOTPModel) Delete(userID int64) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GenerateRecoveryCodes returns a fresh set of recovery codes, formatted as two
+// groups of five characters to make them easier to write down.
+func GenerateRecoveryCodes() ([]string, error) {
+
+	// 32 characters, so that every random byte maps to one of them without bias.
+	// Characters which are easily mistaken for one another are left out.
+	const alphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+
+	codes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, 10)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+
+		for j := range b {
+			b[j] = alphabet[b[j]&31]
+		}
+
+		codes[i] = string(b[:5]) + "-" + string(b[5:])
+	}
+
+	return codes, nil
+}
+
+// hashRecoveryCode hashes a recovery code the way the token plaintexts are hashed,
+// after dropping the formatting a user may or may not have typed in.
+func hashRecoveryCode(code string) [32]byte {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return sha256.Sum256([]byte(code))
+}
+
+func ValidateTOTPCode(v *validator.Validator, code string) {
+	v.Check(code != "", "totp_code", "must be provided")
+	v.Check(len(code) == 6, "totp_code", "must be 6 digits long")
+}
@@ -0,0 +1,169 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrParentTrashed is returned when restoring a subtask whose parent is still in the
+// trash.
+var ErrParentTrashed = errors.New("parent todo is in the trash")
+
+// GetTrash returns the todos in the user's trash, the most recently deleted first.
+// Subtasks which were deleted along with their parent are left out, they come back
+// when it's restored.
+func (m *TodoModel) GetTrash(userID int64, p Pagination) ([]*Todo, Metadata, error) {
+
+	query := `
+		SELECT count(*) OVER(), ` + todoColumns + `
+		FROM todo
+		WHERE user_id = $1
+			AND deleted_at IS NOT NULL
+			AND NOT EXISTS (
+				SELECT 1 FROM todo AS parent
+				WHERE parent.id = todo.parent_id
+					AND parent.deleted_at IS NOT NULL
+			)
+		ORDER BY deleted_at DESC, id DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, p.limit(), p.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	todos := []*Todo{}
+	totalRecords := 0
+
+	for rows.Next() {
+		todo, err := scanTodo(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		todos = append(todos, todo)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	err = attachTags(ctx, m.DB, todos...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, p.Page, p.PageSize)
+	return todos, metadata, nil
+}
+
+// Restore takes a todo out of the trash, along with the subtasks which were deleted
+// with it. A subtask can't be restored while its parent is still in the trash.
+func (m *TodoModel) Restore(id, userID int64) (*Todo, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT todo.deleted_at, coalesce(parent.deleted_at IS NOT NULL, false)
+		FROM todo
+			LEFT JOIN todo AS parent ON parent.id = todo.parent_id
+		WHERE todo.id = $1
+			AND todo.user_id = $2
+			AND todo.deleted_at IS NOT NULL
+		FOR UPDATE OF todo
+	`
+
+	var deletedAt time.Time
+	var parentTrashed bool
+
+	err = tx.QueryRowContext(ctx, query, id, userID).Scan(&deletedAt, &parentTrashed)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if parentTrashed {
+		return nil, ErrParentTrashed
+	}
+
+	query = `
+		WITH RECURSIVE subtree AS (
+			SELECT id FROM todo WHERE id = $1
+			UNION ALL
+			SELECT todo.id FROM todo INNER JOIN subtree ON todo.parent_id = subtree.id
+			WHERE todo.deleted_at = $2
+		)
+		UPDATE todo
+			SET deleted_at = NULL, version = version + 1
+			WHERE id IN (SELECT id FROM subtree)
+	`
+
+	_, err = tx.ExecContext(ctx, query, id, deletedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Get(id, userID)
+}
+
+// DeletePermanently removes a todo which is in the trash for good, its subtasks go
+// with it through the foreign key.
+func (m *TodoModel) DeletePermanently(id, userID int64) error {
+
+	query := `
+		DELETE FROM todo
+		WHERE id = $1
+			AND user_id = $2
+			AND deleted_at IS NOT NULL
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// PurgeTrash removes the todos which have been in the trash for longer than retention.
+func (m *TodoModel) PurgeTrash(retention time.Duration) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM todo WHERE deleted_at < $1`, time.Now().Add(-retention))
+	return err
+}
@@ -17,6 +17,9 @@ var (
 	ErrDuplicateEmail = errors.New("duplicate email")
 )
 
+// DefaultTimeZone is the time zone of users who haven't chosen one.
+const DefaultTimeZone = "UTC"
+
 // UserModel for model dependencies in app struct
 // so that we can access thes methods (Get, Insert...) from the handlers
 type UserModel struct {
@@ -29,9 +32,20 @@ type User struct {
 	Email        string    `json:"email"`
 	Password     password  `json:"-"`
 	Activated    bool      `json:"activated"`
+	TimeZone     string    `json:"time_zone"`
+	Version      int32     `json:"-"`
 	CreationTime time.Time `json:"creation_time"`
 }
 
+// Location returns the user's time zone, falling back to UTC if it's unset or unknown.
+func (u *User) Location() *time.Location {
+	loc, err := time.LoadLocation(u.TimeZone)
+	if err != nil || u.TimeZone == "" {
+		return time.UTC
+	}
+	return loc
+}
+
 // Create a custom password type which is a struct containing the plaintext and hashed
 // versions of the password for a user. The plaintext field is a *pointer* to a string,
 // so that we're able to distinguish between a plaintext password not being present in
@@ -55,6 +69,16 @@ func (p *password) Set(plaintextPassword string) error {
 	return nil
 }
 
+// dummyPasswordHash is a bcrypt hash with the same cost as the ones created by Set().
+var dummyPasswordHash = []byte("$2a$12$BwwsMUtueze3Ddo7AmVdAeQl/qq4otaHNuKPPe9wOz72aiaourA.q")
+
+// DummyPasswordMatches does the same work as checking a real password, and always
+// fails. It is used when there's no user to check the password against, so that the
+// response time doesn't tell whether an account exists.
+func DummyPasswordMatches(plaintextPassword string) {
+	_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(plaintextPassword))
+}
+
 func (p *password) Matches(plaintextPassword string) (bool, error) {
 
 	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
@@ -73,28 +97,42 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 }
 
 // database methods
-func (m *UserModel) Insert(user *User) error {
+
+// Insert creates the user and grants them the permissions of role, in one transaction
+// so that a user can't end up without any.
+func (m *UserModel) Insert(user *User, role string) error {
 
 	query :=
 		`
 		INSERT INTO 
-			users (name, email, password_hash, activated)
-			VALUES ($1, $2, $3, $4)
+			users (name, email, password_hash, activated, time_zone)
+			VALUES ($1, $2, $3, $4, $5)
 		RETURNING
-			id, creation_time
+			id, creation_time, version
 		`
+	if user.TimeZone == "" {
+		user.TimeZone = DefaultTimeZone
+	}
+
 	// args
-	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
+	args := []any{user.Name, user.Email, user.Password.hash, user.Activated, user.TimeZone}
 
 	// create a context for limiting the db-query time limit
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	// run the db query
 	// then Scan the returning values to the user struct
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+	err = tx.QueryRowContext(ctx, query, args...).Scan(
 		&user.ID,
 		&user.CreationTime,
+		&user.Version,
 	)
 
 	// check if there is any error!
@@ -106,6 +144,16 @@ func (m *UserModel) Insert(user *User) error {
 			return err
 		}
 	}
+
+	err = addRoleForUser(ctx, tx, user.ID, role)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
 	fmt.Println("in the data -> DB insertion success!")
 	return nil
 }
@@ -119,7 +167,7 @@ func (m *UserModel) GetByToken(scope, tokenPlainText string) (*User, error) {
 	// Set up the SQL query.
 	query := `
 		SELECT 
-			users.id, users.creation_time, users.name, users.email, users.password_hash, users.activated
+			users.id, users.creation_time, users.name, users.email, users.password_hash, users.activated, users.time_zone, users.version
 		FROM users
 				INNER JOIN token
 				ON users.id = token.user_id
@@ -146,6 +194,8 @@ func (m *UserModel) GetByToken(scope, tokenPlainText string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.TimeZone,
+		&user.Version,
 	)
 
 	if err != nil {
@@ -160,11 +210,47 @@ func (m *UserModel) GetByToken(scope, tokenPlainText string) (*User, error) {
 	return &user, nil
 }
 
+func (m *UserModel) Get(id int64) (*User, error) {
+
+	query := `
+		SELECT 
+			id, name, email, password_hash, activated, time_zone, version, creation_time
+		FROM users
+		WHERE 
+			id = $1
+	`
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.TimeZone,
+		&user.Version,
+		&user.CreationTime,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
 func (m *UserModel) GetByEmail(email string) (*User, error) {
 
 	query := `
 		SELECT 
-			id, name, email, password_hash, activated, creation_time
+			id, name, email, password_hash, activated, time_zone, version, creation_time
 		FROM users
 		WHERE 
 			email = $1
@@ -181,6 +267,8 @@ func (m *UserModel) GetByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.TimeZone,
+		&user.Version,
 		&user.CreationTime,
 	)
 
@@ -195,6 +283,338 @@ func (m *UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
+// GetAll returns a page of users whose name or email contains the search term (case
+// insensitively). An empty search term matches every user.
+func (m *UserModel) GetAll(search string, q Queries) ([]*User, Metadata, error) {
+
+	query := fmt.Sprintf(`
+		SELECT
+			count(*) OVER(), id, name, email, activated, time_zone, version, creation_time
+		FROM users
+			WHERE
+				(strpos(lower(name), lower($1)) > 0
+					OR strpos(lower(email::text), lower($1)) > 0
+					OR $1 = '')
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`,
+		q.Sorts.sortColumn(), q.Sorts.sortDirection())
+
+	args := []any{search, q.Pagination.limit(), q.Pagination.offset()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	totalRecords := 0
+
+	for rows.Next() {
+
+		var user User
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.Name,
+			&user.Email,
+			&user.Activated,
+			&user.TimeZone,
+			&user.Version,
+			&user.CreationTime,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		users = append(users, &user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, q.Pagination.Page, q.Pagination.PageSize)
+	return users, metadata, nil
+}
+
+// Delete removes a user along with everything they own. The todos have to go first,
+// as the foreign key from todo to users doesn't cascade.
+func (m *UserModel) Delete(id int64) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM todo WHERE user_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+// Update the details of a specific user. The version column is checked in the WHERE
+// clause so that two concurrent updates to the same user can't silently overwrite
+// each other; if no row matches we treat it as an edit conflict.
+//
+// All-day due dates are stored as midnight in the user's time zone, so when it
+// changes they are moved to midnight of the same day in the new one. Otherwise they
+// would show up on the wrong day.
+func (m *UserModel) Update(user *User) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	var oldTimeZone string
+
+	err = tx.QueryRowContext(ctx, `SELECT time_zone FROM users WHERE id = $1 FOR UPDATE`, user.ID).Scan(&oldTimeZone)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	query := `
+		UPDATE users
+			SET
+				name = $1,
+				email = $2,
+				password_hash = $3,
+				activated = $4,
+				time_zone = $5,
+				version = version + 1
+			WHERE id = $6
+				AND version = $7
+		RETURNING version
+	`
+	args := []any{
+		user.Name,
+		user.Email,
+		user.Password.hash,
+		user.Activated,
+		user.TimeZone,
+		user.ID,
+		user.Version,
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if user.TimeZone != oldTimeZone {
+		err = reanchorAllDayTodos(ctx, tx, user.ID, oldTimeZone, user.TimeZone)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// reanchorAllDayTodos moves the all-day due dates of a user from midnight in one time
+// zone to midnight of the same day in another. All-day series which repeat in the old
+// time zone move along with them, so that their next occurrences stay on midnight.
+func reanchorAllDayTodos(ctx context.Context, tx *sql.Tx, userID int64, from, to string) error {
+
+	query := `
+		UPDATE todo
+			SET
+				due_at = ((due_at AT TIME ZONE $2)::date)::timestamp AT TIME ZONE $3,
+				rrule_time_zone = CASE WHEN rrule_time_zone = $2 THEN $3 ELSE rrule_time_zone END,
+				version = version + 1
+			WHERE user_id = $1
+				AND all_day
+				AND due_at IS NOT NULL
+	`
+
+	_, err := tx.ExecContext(ctx, query, userID, from, to)
+	return err
+}
+
+// ResetPassword saves the new password hash of a user and revokes every token
+// belonging to them (of any scope) in a single transaction, so that a successful
+// reset also logs out all existing sessions and invalidates any other reset links.
+func (m *UserModel) ResetPassword(user *User) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users
+			SET
+				password_hash = $1,
+				version = version + 1
+			WHERE id = $2
+				AND version = $3
+		RETURNING version
+	`
+	err = tx.QueryRowContext(ctx, query, user.Password.hash, user.ID, user.Version).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM token WHERE user_id = $1`, user.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RequestEmailChange remembers the address the user wants to switch to and returns a
+// token, valid for ttl, to confirm it with. It only takes effect once confirmed, and
+// replaces any earlier request. The tokens of earlier requests are deleted, as they
+// were mailed to another address and would otherwise confirm this one.
+func (m *UserModel) RequestEmailChange(userID int64, email string, ttl time.Duration) (*Token, error) {
+
+	token, err := generateToken(userID, ttl, ScopeEmailChange)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	// the upsert locks the user's row, so concurrent requests take turns
+	query := `
+		INSERT INTO email_changes (user_id, new_email)
+			VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+			SET new_email = EXCLUDED.new_email, creation_time = now()
+	`
+
+	_, err = tx.ExecContext(ctx, query, userID, email)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM token WHERE user_id = $1 AND scope = $2`, userID, ScopeEmailChange)
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertToken(ctx, tx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// ConfirmEmailChange switches the user to the address of their pending email change,
+// and deletes the request along with its confirmation tokens.
+func (m *UserModel) ConfirmEmailChange(user *User) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users
+			SET
+				email = email_changes.new_email,
+				version = users.version + 1
+			FROM email_changes
+			WHERE users.id = $1
+				AND users.version = $2
+				AND email_changes.user_id = users.id
+		RETURNING users.email, users.version
+	`
+	err = tx.QueryRowContext(ctx, query, user.ID, user.Version).Scan(&user.Email, &user.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM email_changes WHERE user_id = $1`, user.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM token WHERE user_id = $1 AND scope = $2`, user.ID, ScopeEmailChange)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 // validation methods for users
 func ValidateEmail(v *validator.Validator, email string) {
 
@@ -209,11 +629,24 @@ func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
 }
 
+// ValidateTimeZone checks that the time zone is an IANA name such as "Europe/Berlin".
+// "Local" is rejected as it would mean the time zone of the server.
+func ValidateTimeZone(v *validator.Validator, timeZone string) {
+
+	_, err := time.LoadLocation(timeZone)
+	v.Check(timeZone != "" && timeZone != "Local" && err == nil, "time_zone", "must be a valid IANA time zone")
+}
+
 func ValidateUser(v *validator.Validator, user *User) {
 
 	v.Check(user.Name != "", "name", "must be provided")
 	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 characters long")
 
+	// An empty time zone is fine, Insert() fills in the default.
+	if user.TimeZone != "" {
+		ValidateTimeZone(v, user.TimeZone)
+	}
+
 	// Call the standalone ValidateEmail() helper.
 	ValidateEmail(v, user.Email)
 
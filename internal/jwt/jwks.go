@@ -0,0 +1,119 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// JWK is a single public key in the JSON Web Key format. Only the members needed for
+// RSA and Ed25519 keys are supported.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg,omitempty"`
+	Use       string `json:"use,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// OKP, i.e. Ed25519
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set, as served on the jwks_uri of an OpenID provider.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// ParseJWKS returns a verifying KeySet holding every usable key of the set. Keys of
+// other types, or meant for encryption, are skipped.
+func ParseJWKS(b []byte) (*KeySet, error) {
+
+	var set JWKS
+	err := json.Unmarshal(b, &set)
+	if err != nil {
+		return nil, fmt.Errorf("malformed JWKS: %w", err)
+	}
+
+	var keys []*Key
+
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		var key *Key
+
+		switch {
+		case jwk.KeyType == "RSA" && (jwk.Algorithm == "" || jwk.Algorithm == RS256):
+			n, err := encoding.DecodeString(jwk.N)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: malformed modulus", jwk.KeyID)
+			}
+			e, err := encoding.DecodeString(jwk.E)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: malformed exponent", jwk.KeyID)
+			}
+
+			public := &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+
+			key, err = NewRS256PublicKey(jwk.KeyID, public)
+			if err != nil {
+				return nil, err
+			}
+		case jwk.KeyType == "OKP" && jwk.Curve == "Ed25519":
+			x, err := encoding.DecodeString(jwk.X)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: malformed public key", jwk.KeyID)
+			}
+
+			key, err = NewEdDSAPublicKey(jwk.KeyID, ed25519.PublicKey(x))
+			if err != nil {
+				return nil, err
+			}
+		default:
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+
+	return NewVerifyingKeySet(keys...)
+}
+
+// JWKS returns the public keys of the set. Symmetric keys are secret and left out.
+func (ks *KeySet) JWKS() JWKS {
+
+	set := JWKS{Keys: []JWK{}}
+
+	for _, key := range ks.keys {
+		switch key.Algorithm {
+		case RS256:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "RSA",
+				KeyID:     key.ID,
+				Algorithm: RS256,
+				Use:       "sig",
+				N:         encoding.EncodeToString(key.rsaPublic.N.Bytes()),
+				E:         encoding.EncodeToString(big.NewInt(int64(key.rsaPublic.E)).Bytes()),
+			})
+		case EdDSA:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "OKP",
+				KeyID:     key.ID,
+				Algorithm: EdDSA,
+				Use:       "sig",
+				Curve:     "Ed25519",
+				X:         encoding.EncodeToString(key.public),
+			})
+		}
+	}
+
+	return set
+}
@@ -0,0 +1,183 @@
+// Package jwt implements the small subset of JSON Web Tokens (RFC 7519) that we
+// need: compact JWS tokens signed with HS256, RS256 or EdDSA, selected by a "kid"
+// header, and the JSON Web Key Sets (RFC 7517) identity providers publish their keys
+// with.
+package jwt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token has expired")
+	ErrUnknownKey   = errors.New("unknown signing key")
+)
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+// Claims is implemented by every claims struct passed to Parse(). Embedding
+// RegisteredClaims is enough to satisfy it.
+type Claims interface {
+	Validate(now time.Time) error
+}
+
+// RegisteredClaims holds the claims defined by RFC 7519 which we care about.
+type RegisteredClaims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+}
+
+// Validate checks the time based claims. A token without an expiry is never valid.
+func (c RegisteredClaims) Validate(now time.Time) error {
+
+	if c.ExpiresAt == 0 || now.Unix() >= c.ExpiresAt {
+		return ErrExpiredToken
+	}
+	if c.NotBefore != 0 && now.Unix() < c.NotBefore {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// Audience is the "aud" claim, which may either be a single string or an array of
+// strings in the JSON encoding.
+type Audience []string
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+
+	if bytes.HasPrefix(b, []byte(`"`)) {
+		var s string
+		err := json.Unmarshal(b, &s)
+		if err != nil {
+			return err
+		}
+		*a = Audience{s}
+		return nil
+	}
+
+	var list []string
+	err := json.Unmarshal(b, &list)
+	if err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// Contains reports whether the audience includes value.
+func (a Audience) Contains(value string) bool {
+	for _, v := range a {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+var encoding = base64.RawURLEncoding
+
+// Sign encodes the claims and signs them with the signing key of the set.
+func (ks *KeySet) Sign(claims any) (string, error) {
+
+	if ks.signing == nil {
+		return "", errors.New("key set has no signing key")
+	}
+
+	h, err := json.Marshal(header{Algorithm: ks.signing.Algorithm, Type: "JWT", KeyID: ks.signing.ID})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	input := encoding.EncodeToString(h) + "." + encoding.EncodeToString(payload)
+
+	signature, err := ks.signing.sign([]byte(input))
+	if err != nil {
+		return "", err
+	}
+
+	return input + "." + encoding.EncodeToString(signature), nil
+}
+
+// Parse verifies the signature of the token, decodes its payload into claims and
+// validates them. The algorithm in the header must match the one of the key it
+// names, so that a token can never pick how it is verified.
+func (ks *KeySet) Parse(token string, claims Claims) error {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrInvalidToken
+	}
+
+	rawHeader, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	var h header
+	err = json.Unmarshal(rawHeader, &h)
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	key, ok := ks.keys[h.KeyID]
+	if !ok {
+		return ErrUnknownKey
+	}
+	if h.Algorithm != key.Algorithm {
+		return ErrInvalidToken
+	}
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	if !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return ErrInvalidToken
+	}
+
+	payload, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	err = json.Unmarshal(payload, claims)
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	return claims.Validate(time.Now())
+}
+
+// LooksLikeJWT reports whether token has the shape of a compact JWS, without
+// verifying anything. It is used to tell JWTs apart from opaque tokens.
+func LooksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
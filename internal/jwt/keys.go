@@ -0,0 +1,171 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// Supported signing algorithms, using their JWA names.
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+// Key is a single signing/verification key, identified by its ID which ends up in the
+// "kid" header of every token signed with it.
+type Key struct {
+	ID        string
+	Algorithm string
+
+	secret     []byte
+	private    ed25519.PrivateKey
+	public     ed25519.PublicKey
+	rsaPrivate *rsa.PrivateKey
+	rsaPublic  *rsa.PublicKey
+}
+
+// NewHS256Key returns a HMAC-SHA256 key. The secret must be at least 32 bytes long, as
+// recommended by RFC 7518 for this algorithm.
+func NewHS256Key(id string, secret []byte) (*Key, error) {
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("key %q: HS256 secret must be at least 32 bytes long", id)
+	}
+	return &Key{ID: id, Algorithm: HS256, secret: secret}, nil
+}
+
+// NewEdDSAKey returns an Ed25519 key derived from a 32 byte seed.
+func NewEdDSAKey(id string, seed []byte) (*Key, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("key %q: EdDSA seed must be %d bytes long", id, ed25519.SeedSize)
+	}
+	private := ed25519.NewKeyFromSeed(seed)
+	return &Key{
+		ID:        id,
+		Algorithm: EdDSA,
+		private:   private,
+		public:    private.Public().(ed25519.PublicKey),
+	}, nil
+}
+
+// NewEdDSAPublicKey returns an Ed25519 key which can only verify signatures.
+func NewEdDSAPublicKey(id string, public ed25519.PublicKey) (*Key, error) {
+	if len(public) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("key %q: EdDSA public key must be %d bytes long", id, ed25519.PublicKeySize)
+	}
+	return &Key{ID: id, Algorithm: EdDSA, public: public}, nil
+}
+
+// NewRS256Key returns an RSA key. RS256 is what most identity providers sign their
+// tokens with, so it's mostly here to verify those.
+func NewRS256Key(id string, private *rsa.PrivateKey) (*Key, error) {
+	if private.N.BitLen() < 2048 {
+		return nil, fmt.Errorf("key %q: RSA keys must be at least 2048 bits long", id)
+	}
+	return &Key{ID: id, Algorithm: RS256, rsaPrivate: private, rsaPublic: &private.PublicKey}, nil
+}
+
+// NewRS256PublicKey returns an RSA key which can only verify signatures.
+func NewRS256PublicKey(id string, public *rsa.PublicKey) (*Key, error) {
+	if public.N.BitLen() < 2048 {
+		return nil, fmt.Errorf("key %q: RSA keys must be at least 2048 bits long", id)
+	}
+	return &Key{ID: id, Algorithm: RS256, rsaPublic: public}, nil
+}
+
+// NewKey returns a key for the given algorithm name.
+func NewKey(id, algorithm string, material []byte) (*Key, error) {
+	switch algorithm {
+	case HS256:
+		return NewHS256Key(id, material)
+	case EdDSA:
+		return NewEdDSAKey(id, material)
+	default:
+		return nil, fmt.Errorf("key %q: unsupported algorithm %q", id, algorithm)
+	}
+}
+
+func (k *Key) sign(input []byte) ([]byte, error) {
+	switch k.Algorithm {
+	case HS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(input)
+		return mac.Sum(nil), nil
+	case EdDSA:
+		if k.private == nil {
+			return nil, fmt.Errorf("key %q can only be used for verification", k.ID)
+		}
+		return ed25519.Sign(k.private, input), nil
+	case RS256:
+		if k.rsaPrivate == nil {
+			return nil, fmt.Errorf("key %q can only be used for verification", k.ID)
+		}
+		digest := sha256.Sum256(input)
+		return rsa.SignPKCS1v15(rand.Reader, k.rsaPrivate, crypto.SHA256, digest[:])
+	default:
+		return nil, fmt.Errorf("key %q: unsupported algorithm %q", k.ID, k.Algorithm)
+	}
+}
+
+func (k *Key) verify(input, signature []byte) bool {
+	switch k.Algorithm {
+	case HS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(input)
+		return hmac.Equal(signature, mac.Sum(nil))
+	case EdDSA:
+		return ed25519.Verify(k.public, input, signature)
+	case RS256:
+		digest := sha256.Sum256(input)
+		return rsa.VerifyPKCS1v15(k.rsaPublic, crypto.SHA256, digest[:], signature) == nil
+	default:
+		return false
+	}
+}
+
+// KeySet holds every key tokens may be verified with, and the one new tokens are
+// signed with. Rotating keys is a matter of adding a new key, making it the signing
+// key, and removing the old one once all tokens signed with it have expired.
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+// NewKeySet returns a KeySet signing with the key identified by signingKeyID.
+func NewKeySet(signingKeyID string, keys ...*Key) (*KeySet, error) {
+
+	ks, err := NewVerifyingKeySet(keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	signing, ok := ks.keys[signingKeyID]
+	if !ok {
+		return nil, errors.New("signing key is not part of the key set")
+	}
+	ks.signing = signing
+
+	return ks, nil
+}
+
+// NewVerifyingKeySet returns a KeySet which can only verify tokens, such as the keys
+// of another issuer.
+func NewVerifyingKeySet(keys ...*Key) (*KeySet, error) {
+
+	ks := &KeySet{keys: make(map[string]*Key)}
+
+	for _, key := range keys {
+		if _, exists := ks.keys[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		ks.keys[key.ID] = key
+	}
+
+	return ks, nil
+}
@@ -0,0 +1,92 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileMailer keeps every message it is asked to send in memory and, if a directory is
+// configured, also drops each one there as an .eml file. It never talks to the
+// network, which makes it the backend of choice for development and tests.
+type FileMailer struct {
+	dir    string
+	sender string
+
+	mu   sync.Mutex
+	sent []Message
+}
+
+// NewFile returns a FileMailer writing to dir. Pass an empty dir to keep the messages
+// in memory only.
+func NewFile(dir, sender string) (*FileMailer, error) {
+
+	if dir != "" {
+		err := os.MkdirAll(dir, 0o755)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &FileMailer{
+		dir:    dir,
+		sender: sender,
+	}, nil
+}
+
+func (m *FileMailer) Send(recipient, templateFile string, data any) error {
+
+	msg, err := render(m.sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+	msg.SentAt = time.Now()
+
+	if m.dir != "" {
+		body, err := msg.Bytes()
+		if err != nil {
+			return err
+		}
+
+		// make the recipient safe to use as part of a file name
+		name := strings.NewReplacer("@", "_at_", "/", "_", "\\", "_").Replace(recipient)
+		path := filepath.Join(m.dir, fmt.Sprintf("%d-%s.eml", msg.SentAt.UnixNano(), name))
+
+		err = os.WriteFile(path, body, 0o644)
+		if err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, *msg)
+
+	return nil
+}
+
+// Messages returns a copy of every message sent so far, oldest first.
+func (m *FileMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	messages := make([]Message, len(m.sent))
+	copy(messages, m.sent)
+	return messages
+}
+
+// Last returns the most recent message sent to recipient, or false if there is none.
+func (m *FileMailer) Last(recipient string) (Message, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.sent) - 1; i >= 0; i-- {
+		if m.sent[i].To == recipient {
+			return m.sent[i], true
+		}
+	}
+	return Message{}, false
+}
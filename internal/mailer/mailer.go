@@ -0,0 +1,76 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"text/template"
+	"time"
+)
+
+// Below we declare a new variable with the type embed.FS (embedded file system) to hold
+// our email templates. This has a comment directive in the format `//go:embed <path>`
+// IMMEDIATELY ABOVE it, which indicates to Go that we want to store the contents of the
+// ./templates directory in the templateFS embedded file system variable.
+//
+//go:embed "templates"
+var templateFS embed.FS
+
+// Mailer is implemented by every mail backend. The templateFile is the name of a file
+// in the embedded templates directory, which must define the "subject", "plainBody"
+// and "htmlBody" templates; data is passed to all three of them.
+type Mailer interface {
+	Send(recipient, templateFile string, data any) error
+}
+
+// Message holds a fully rendered email, ready to be handed over to a backend.
+type Message struct {
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Subject   string    `json:"subject"`
+	PlainBody string    `json:"plain_body"`
+	HTMLBody  string    `json:"html_body"`
+	SentAt    time.Time `json:"sent_at"`
+}
+
+// render executes the named template file and returns the resulting message. The
+// subject and plain-text body are rendered with text/template, while the HTML body
+// goes through html/template so that any dynamic data is escaped properly.
+func render(sender, recipient, templateFile string, data any) (*Message, error) {
+
+	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return nil, err
+	}
+
+	plainBody := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlTmpl, err := htmltemplate.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		From:      sender,
+		To:        recipient,
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+	}, nil
+}
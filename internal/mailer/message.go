@@ -0,0 +1,64 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"time"
+)
+
+// Bytes encodes the message as a multipart/alternative MIME document, with the
+// plain-text part first and the HTML part second so that clients prefer the HTML.
+func (msg *Message) Bytes() ([]byte, error) {
+
+	buf := new(bytes.Buffer)
+	body := multipart.NewWriter(buf)
+
+	// write the top level headers before the parts
+	header := new(bytes.Buffer)
+	fmt.Fprintf(header, "From: %s\r\n", msg.From)
+	fmt.Fprintf(header, "To: %s\r\n", msg.To)
+	fmt.Fprintf(header, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(header, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(header, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(header, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", body.Boundary())
+
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=UTF-8", msg.PlainBody},
+		{"text/html; charset=UTF-8", msg.HTMLBody},
+	}
+
+	for _, part := range parts {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Type", part.contentType)
+		h.Set("Content-Transfer-Encoding", "quoted-printable")
+
+		pw, err := body.CreatePart(h)
+		if err != nil {
+			return nil, err
+		}
+
+		qp := quotedprintable.NewWriter(pw)
+		_, err = qp.Write([]byte(part.content))
+		if err != nil {
+			return nil, err
+		}
+		err = qp.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(header.Bytes(), buf.Bytes()...), nil
+}
@@ -0,0 +1,135 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// SMTPMailer delivers messages through an SMTP server.
+type SMTPMailer struct {
+	host     string
+	port     int
+	username string
+	password string
+	sender   string
+	timeout  time.Duration
+}
+
+// NewSMTP returns a Mailer which talks to the SMTP server at host:port. If username
+// is empty no authentication is attempted, which is handy for local relays.
+func NewSMTP(host string, port int, username, password, sender string) *SMTPMailer {
+	return &SMTPMailer{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+		sender:   sender,
+		timeout:  5 * time.Second,
+	}
+}
+
+func (m *SMTPMailer) Send(recipient, templateFile string, data any) error {
+
+	msg, err := render(m.sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	body, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	// Try sending the email up to three times before aborting and returning the final
+	// error. We sleep for 500 milliseconds between each attempt.
+	const retries = 3
+	for i := 0; i < retries; i++ {
+		err = m.deliver(msg, body)
+		// If everything worked, return nil.
+		if nil == err {
+			return nil
+		}
+		// If it didn't work, sleep for a short time and retry. There's no point in
+		// waiting after the last attempt, it only holds up shutdown.
+		if i < retries-1 {
+			time.Sleep(500 * time.Millisecond)
+		}
+	}
+
+	return err
+}
+
+// deliver runs a single SMTP conversation. We don't use smtp.SendMail() here because
+// it offers no way to put a deadline on a slow or unresponsive server.
+func (m *SMTPMailer) deliver(msg *Message, body []byte) error {
+
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return err
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(m.host, strconv.Itoa(m.port))
+
+	conn, err := net.DialTimeout("tcp", addr, m.timeout)
+	if err != nil {
+		return err
+	}
+	err = conn.SetDeadline(time.Now().Add(m.timeout))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: m.host})
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.username != "" {
+		err = c.Auth(smtp.PlainAuth("", m.username, m.password, m.host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.Mail(from.Address)
+	if err != nil {
+		return err
+	}
+	err = c.Rcpt(to.Address)
+	if err != nil {
+		return err
+	}
+
+	wc, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = wc.Write(body)
+	if err != nil {
+		return err
+	}
+	err = wc.Close()
+	if err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
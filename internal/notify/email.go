@@ -0,0 +1,24 @@
+package notify
+
+import (
+	"context"
+
+	"github.com/ridwanulhoquejr/todo-app/internal/mailer"
+)
+
+// Email sends notifications to the user's email address.
+type Email struct {
+	Mailer mailer.Mailer
+}
+
+func (c *Email) Send(ctx context.Context, n *Notification) error {
+
+	data := map[string]any{
+		"name":    n.Name,
+		"todoID":  n.TodoID,
+		"title":   n.Title,
+		"dueText": n.DueText,
+	}
+
+	return c.Mailer.Send(n.Email, "todo_reminder.tmpl", data)
+}
@@ -0,0 +1,24 @@
+package notify
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/ridwanulhoquejr/todo-app/internal/jsonlog"
+)
+
+// Log writes notifications to the application log, which is handy during development.
+type Log struct {
+	Logger *jsonlog.Logger
+}
+
+func (c *Log) Send(ctx context.Context, n *Notification) error {
+
+	c.Logger.PrintInfo("reminder", map[string]string{
+		"email":   n.Email,
+		"todo_id": strconv.FormatInt(n.TodoID, 10),
+		"title":   n.Title,
+		"due":     n.DueText,
+	})
+	return nil
+}
@@ -0,0 +1,31 @@
+// Package notify delivers notifications about todos, such as reminders, over one of
+// several channels. Channels don't retry on their own; a failed Send is retried by
+// the caller.
+package notify
+
+import (
+	"context"
+	"time"
+)
+
+// Notification is what gets delivered, whatever the channel.
+type Notification struct {
+	// Email and Name are those of the user the notification is for.
+	Email string
+	Name  string
+
+	TodoID int64
+	Title  string
+	// DueAt is nil for todos without a due date. DueText is the same time formatted
+	// in the user's time zone, for channels meant to be read by people.
+	DueAt   *time.Time
+	DueText string
+
+	// WebhookURL is where the webhook channel posts the notification to.
+	WebhookURL string
+}
+
+// Channel is implemented by every way of delivering a notification.
+type Channel interface {
+	Send(ctx context.Context, n *Notification) error
+}
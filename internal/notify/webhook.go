@@ -0,0 +1,165 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress is returned when a webhook would reach an address which isn't on
+// the public internet, such as the loopback interface, a private network or the
+// metadata service of a cloud provider.
+var ErrForbiddenAddress = errors.New("notify: webhook address is not public")
+
+// forbiddenPrefixes are the ranges webhooks can't be sent to on top of the loopback,
+// private, link-local, multicast and unspecified addresses.
+var forbiddenPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "this" network
+	netip.MustParsePrefix("100.64.0.0/10"),  // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved, and the broadcast address
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64, which embeds IPv4 addresses
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local-use NAT64
+	netip.MustParsePrefix("2002::/16"),      // 6to4, which embeds IPv4 addresses
+	netip.MustParsePrefix("fec0::/10"),      // deprecated site-local
+	netip.MustParsePrefix("2001::/23"),      // IETF protocol assignments, Teredo among them
+}
+
+// PublicAddr tells whether webhooks may be sent to the address.
+func PublicAddr(addr netip.Addr) bool {
+
+	addr = addr.Unmap()
+
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() {
+		return false
+	}
+
+	for _, prefix := range forbiddenPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateWebhookURL checks that a webhook URL is an absolute https URL, and that its
+// host isn't an address webhooks can't be sent to. Host names are only resolved when
+// the webhook is sent, so the address they point to is checked then.
+func ValidateWebhookURL(rawURL string) error {
+
+	u, err := url.Parse(rawURL)
+	if err != nil || !u.IsAbs() || u.Hostname() == "" {
+		return errors.New("must be an absolute https URL")
+	}
+
+	if u.Scheme != "https" {
+		return errors.New("must be an absolute https URL")
+	}
+
+	if u.User != nil {
+		return errors.New("must not contain credentials")
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errors.New("must not point to a local address")
+	}
+
+	if addr, err := netip.ParseAddr(host); err == nil && !PublicAddr(addr) {
+		return errors.New("must not point to a local or private address")
+	}
+	return nil
+}
+
+// Webhook posts notifications as JSON to the URL of the notification. Any response
+// other than a 2xx counts as a failure, redirects included.
+type Webhook struct {
+	Client *http.Client
+}
+
+// NewWebhook returns a Webhook channel which gives up on a request after timeout. Its
+// client refuses to connect to anything but public addresses. The check is done on
+// the address actually dialled, so a host name which resolves to a private address
+// (or starts to, after the URL was validated) is refused as well.
+func NewWebhook(timeout time.Duration) *Webhook {
+
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !PublicAddr(addrPort.Addr()) {
+				return ErrForbiddenAddress
+			}
+			return nil
+		},
+	}
+
+	transport := &http.Transport{
+		// a proxy would be dialled instead of the webhook, bypassing the check
+		Proxy:               nil,
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: timeout,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+	}
+
+	return &Webhook{Client: &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		// a redirect could point anywhere, so the response is taken as it is
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}
+}
+
+func (c *Webhook) Send(ctx context.Context, n *Notification) error {
+
+	// the URL was checked when the reminder was created, but the rules may have
+	// become stricter since
+	err := ValidateWebhookURL(n.WebhookURL)
+	if err != nil {
+		return fmt.Errorf("notify: webhook URL %s", err)
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"event":   "todo.reminder",
+		"todo_id": n.TodoID,
+		"title":   n.Title,
+		"due_at":  n.DueAt,
+		"sent_at": time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("notify: webhook responded with %s", resp.Status)
+	}
+	return nil
+}
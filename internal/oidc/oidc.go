@@ -0,0 +1,298 @@
+// Package oidc implements the relying party side of the OpenID Connect
+// authorization code flow with PKCE: discovery, building the authorization URL,
+// exchanging the code and verifying the ID token.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ridwanulhoquejr/todo-app/internal/jwt"
+)
+
+var (
+	// ErrInvalidIDToken is returned when the ID token can't be trusted, e.g. it is
+	// signed by an unknown key, was issued to another client or for another login.
+	ErrInvalidIDToken = errors.New("oidc: invalid ID token")
+	// ErrExchangeFailed is returned when the provider rejects the authorization code.
+	ErrExchangeFailed = errors.New("oidc: code exchange failed")
+)
+
+// keysRefreshInterval limits how often the keys of a provider are fetched again when
+// a token names a key we don't know, which happens after the provider rotated them.
+const keysRefreshInterval = 5 * time.Minute
+
+// Config of a single identity provider.
+type Config struct {
+	// Name identifies the provider in our routes, e.g. /auth/oidc/{name}
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Claims are the claims of an ID token we make use of.
+type Claims struct {
+	jwt.RegisteredClaims
+	Nonce         string `json:"nonce"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is an OpenID provider we accept logins from. Its metadata and keys are
+// fetched on first use and cached.
+type Provider struct {
+	Config Config
+	client *http.Client
+
+	mu          sync.Mutex
+	metadata    *discovery
+	keys        *jwt.KeySet
+	keysFetched time.Time
+}
+
+// New returns a provider for cfg. Nothing is fetched until the provider is used, so
+// an identity provider being down doesn't stop the application from starting.
+func New(cfg Config) *Provider {
+
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	return &Provider{
+		Config: cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// AuthCodeURL returns the URL to send the user to, to log in at the provider.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.Config.ClientID)
+	params.Set("redirect_uri", p.Config.RedirectURL)
+	params.Set("scope", strings.Join(p.Config.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return metadata.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Exchange trades the authorization code for the tokens of the user, and returns the
+// claims of the verified ID token.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Claims, error) {
+
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.Config.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.Config.ClientID), url.QueryEscape(p.Config.ClientSecret))
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return nil, fmt.Errorf("%w: %s: %s", ErrExchangeFailed, res.Status, body)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+
+	err = json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&tokens)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrExchangeFailed, err)
+	}
+
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("%w: no id_token in response", ErrExchangeFailed)
+	}
+
+	return p.verify(ctx, tokens.IDToken, nonce)
+}
+
+// verify checks the signature and the claims of an ID token, as per section 3.1.3.7
+// of OpenID Connect Core.
+func (p *Provider) verify(ctx context.Context, idToken, nonce string) (*Claims, error) {
+
+	keys, err := p.keySet(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+
+	err = keys.Parse(idToken, &claims)
+	if errors.Is(err, jwt.ErrUnknownKey) {
+		// the provider may have rotated its keys since we last fetched them
+		keys, err = p.keySet(ctx, true)
+		if err != nil {
+			return nil, err
+		}
+		err = keys.Parse(idToken, &claims)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidIDToken, err)
+	}
+
+	switch {
+	case claims.Issuer != p.Config.Issuer:
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, claims.Issuer)
+	case !claims.Audience.Contains(p.Config.ClientID):
+		return nil, fmt.Errorf("%w: issued to another client", ErrInvalidIDToken)
+	case claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	}
+
+	return &claims, nil
+}
+
+// discover fetches the provider metadata from its well-known location.
+func (p *Provider) discover(ctx context.Context) (*discovery, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var metadata discovery
+
+	err := p.getJSON(ctx, strings.TrimSuffix(p.Config.Issuer, "/")+"/.well-known/openid-configuration", &metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	if metadata.Issuer != p.Config.Issuer {
+		return nil, fmt.Errorf("oidc: provider %q claims to be issuer %q", p.Config.Issuer, metadata.Issuer)
+	}
+
+	p.metadata = &metadata
+	return p.metadata, nil
+}
+
+// keySet returns the keys of the provider, fetching them if they're not cached yet or
+// if refresh is set and they haven't been fetched too recently.
+func (p *Provider) keySet(ctx context.Context, refresh bool) (*jwt.KeySet, error) {
+
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.keys != nil && (!refresh || time.Since(p.keysFetched) < keysRefreshInterval) {
+		return p.keys, nil
+	}
+
+	var set json.RawMessage
+
+	err = p.getJSON(ctx, metadata.JWKSURI, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := jwt.ParseJWKS(set)
+	if err != nil {
+		return nil, err
+	}
+
+	p.keys = keys
+	p.keysFetched = time.Now()
+
+	return p.keys, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, dst any) error {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: GET %s: %s", url, res.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(dst)
+}
+
+// RandomString returns a random URL safe string, used for the state, the nonce and
+// the PKCE code verifier.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CodeChallenge derives the S256 PKCE code challenge from a code verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
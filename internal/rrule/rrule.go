@@ -0,0 +1,505 @@
+// Package rrule implements the recurrence rules of RFC 5545 section 3.3.10, for the
+// frequencies which make sense for todos: daily and up. Rules are evaluated against a
+// start time (DTSTART) in some location, so that occurrences keep their wall clock
+// time across daylight saving changes.
+package rrule
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidRule = errors.New("rrule: invalid rule")
+
+// maxPeriods bounds the search for occurrences, for rules which (almost) never match,
+// such as the 31st of February.
+const maxPeriods = 10_000
+
+type Frequency int
+
+const (
+	Daily Frequency = iota
+	Weekly
+	Monthly
+	Yearly
+)
+
+var frequencies = []string{"DAILY", "WEEKLY", "MONTHLY", "YEARLY"}
+
+func (f Frequency) String() string {
+	return frequencies[f]
+}
+
+// WeekdayNum is an entry of BYDAY: a weekday, and for monthly and yearly rules
+// optionally which one of the month or year (1 the first, -1 the last). N is 0 for
+// every such weekday.
+type WeekdayNum struct {
+	N   int
+	Day time.Weekday
+}
+
+var weekdays = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+func (w WeekdayNum) String() string {
+	if w.N == 0 {
+		return weekdays[w.Day]
+	}
+	return strconv.Itoa(w.N) + weekdays[w.Day]
+}
+
+// untilForm is how UNTIL was written, which decides how it's compared.
+type untilForm int
+
+const (
+	untilNone untilForm = iota
+	untilUTC
+	untilLocal
+	untilDate
+)
+
+// Rule is a parsed RRULE. Weeks start on Monday, the only WKST supported.
+type Rule struct {
+	Freq       Frequency
+	Interval   int
+	Count      int
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	ByMonth    []time.Month
+
+	// until holds the UNTIL value; in UTC for untilUTC, otherwise as a wall clock
+	// time which applies in the location of the start time.
+	until     time.Time
+	untilForm untilForm
+}
+
+// Parse parses a rule such as "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR;COUNT=10". A
+// leading "RRULE:" is allowed.
+func Parse(s string) (*Rule, error) {
+
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	if s == "" {
+		return nil, fmt.Errorf("%w: empty rule", ErrInvalidRule)
+	}
+
+	r := &Rule{Interval: 1}
+	seen := map[string]bool{}
+	hasFreq := false
+
+	for _, part := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		name = strings.ToUpper(name)
+		if !ok || value == "" {
+			return nil, fmt.Errorf("%w: malformed part %q", ErrInvalidRule, part)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%w: %s given more than once", ErrInvalidRule, name)
+		}
+		seen[name] = true
+
+		var err error
+
+		switch name {
+		case "FREQ":
+			hasFreq = true
+			r.Freq, err = parseFrequency(value)
+		case "INTERVAL":
+			r.Interval, err = parseInt(value, 1, 1000)
+		case "COUNT":
+			r.Count, err = parseInt(value, 1, 10_000)
+		case "UNTIL":
+			err = r.parseUntil(value)
+		case "BYDAY":
+			r.ByDay, err = parseByDay(value)
+		case "BYMONTHDAY":
+			r.ByMonthDay, err = parseIntList(value, -31, 31)
+		case "BYMONTH":
+			var months []int
+			months, err = parseIntList(value, 1, 12)
+			for _, m := range months {
+				r.ByMonth = append(r.ByMonth, time.Month(m))
+			}
+		case "WKST":
+			if strings.ToUpper(value) != "MO" {
+				err = fmt.Errorf("%w: only WKST=MO is supported", ErrInvalidRule)
+			}
+		default:
+			err = fmt.Errorf("%w: %s is not supported", ErrInvalidRule, name)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !hasFreq {
+		return nil, fmt.Errorf("%w: FREQ is required", ErrInvalidRule)
+	}
+	if r.Count > 0 && r.untilForm != untilNone {
+		return nil, fmt.Errorf("%w: COUNT and UNTIL can't both be given", ErrInvalidRule)
+	}
+
+	for _, wd := range r.ByDay {
+		if wd.N != 0 && r.Freq != Monthly && r.Freq != Yearly {
+			return nil, fmt.Errorf("%w: numbered BYDAY values need a MONTHLY or YEARLY rule", ErrInvalidRule)
+		}
+	}
+	if r.Freq == Weekly && len(r.ByMonthDay) > 0 {
+		return nil, fmt.Errorf("%w: BYMONTHDAY can't be used with a WEEKLY rule", ErrInvalidRule)
+	}
+
+	return r, nil
+}
+
+func parseFrequency(value string) (Frequency, error) {
+	for i, f := range frequencies {
+		if strings.EqualFold(value, f) {
+			return Frequency(i), nil
+		}
+	}
+	return 0, fmt.Errorf("%w: FREQ must be DAILY, WEEKLY, MONTHLY or YEARLY", ErrInvalidRule)
+}
+
+func parseInt(value string, min, max int) (int, error) {
+	i, err := strconv.Atoi(value)
+	if err != nil || i < min || i > max {
+		return 0, fmt.Errorf("%w: %q must be a number from %d to %d", ErrInvalidRule, value, min, max)
+	}
+	return i, nil
+}
+
+// parseIntList parses a comma separated list of numbers from min to max, leaving out
+// zero.
+func parseIntList(value string, min, max int) ([]int, error) {
+	var list []int
+	for _, v := range strings.Split(value, ",") {
+		i, err := parseInt(v, min, max)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			return nil, fmt.Errorf("%w: 0 is not a valid value", ErrInvalidRule)
+		}
+		list = append(list, i)
+	}
+	return list, nil
+}
+
+func parseByDay(value string) ([]WeekdayNum, error) {
+
+	var list []WeekdayNum
+
+	for _, v := range strings.Split(strings.ToUpper(value), ",") {
+		if len(v) < 2 {
+			return nil, fmt.Errorf("%w: invalid BYDAY value %q", ErrInvalidRule, v)
+		}
+
+		day := -1
+		for i, wd := range weekdays {
+			if strings.HasSuffix(v, wd) {
+				day = i
+			}
+		}
+		if day < 0 {
+			return nil, fmt.Errorf("%w: invalid BYDAY value %q", ErrInvalidRule, v)
+		}
+
+		wd := WeekdayNum{Day: time.Weekday(day)}
+		if prefix := v[:len(v)-2]; prefix != "" {
+			n, err := parseInt(strings.TrimPrefix(prefix, "+"), -53, 53)
+			if err != nil || n == 0 {
+				return nil, fmt.Errorf("%w: invalid BYDAY value %q", ErrInvalidRule, v)
+			}
+			wd.N = n
+		}
+		list = append(list, wd)
+	}
+	return list, nil
+}
+
+func (r *Rule) parseUntil(value string) error {
+
+	var err error
+
+	switch {
+	case len(value) == 8:
+		r.untilForm = untilDate
+		r.until, err = time.Parse("20060102", value)
+		r.until = r.until.Add(24*time.Hour - time.Second)
+	case strings.HasSuffix(value, "Z"):
+		r.untilForm = untilUTC
+		r.until, err = time.Parse("20060102T150405Z", value)
+	default:
+		r.untilForm = untilLocal
+		r.until, err = time.Parse("20060102T150405", value)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w: UNTIL must be a date or date-time such as 20261231T235959Z", ErrInvalidRule)
+	}
+	return nil
+}
+
+// String formats the rule the way Parse reads it, without the "RRULE:" prefix.
+func (r *Rule) String() string {
+
+	parts := []string{"FREQ=" + r.Freq.String()}
+
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+
+	switch r.untilForm {
+	case untilDate:
+		parts = append(parts, "UNTIL="+r.until.Format("20060102"))
+	case untilLocal:
+		parts = append(parts, "UNTIL="+r.until.Format("20060102T150405"))
+	case untilUTC:
+		parts = append(parts, "UNTIL="+r.until.Format("20060102T150405Z"))
+	}
+
+	if len(r.ByMonth) > 0 {
+		months := make([]string, len(r.ByMonth))
+		for i, m := range r.ByMonth {
+			months[i] = strconv.Itoa(int(m))
+		}
+		parts = append(parts, "BYMONTH="+strings.Join(months, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		days := make([]string, len(r.ByMonthDay))
+		for i, d := range r.ByMonthDay {
+			days[i] = strconv.Itoa(d)
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, d := range r.ByDay {
+			days[i] = d.String()
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+
+	return strings.Join(parts, ";")
+}
+
+// Next returns up to n occurrences after dtstart, of the series which starts at
+// dtstart. As in RFC 5545, dtstart itself counts as the first occurrence towards
+// COUNT, even when it doesn't match the rule.
+func (r *Rule) Next(dtstart time.Time, n int) []time.Time {
+
+	var until time.Time
+	switch r.untilForm {
+	case untilUTC:
+		until = r.until
+	case untilLocal, untilDate:
+		y, m, d := r.until.Date()
+		h, min, s := r.until.Clock()
+		until = time.Date(y, m, d, h, min, s, 0, dtstart.Location())
+	}
+
+	remaining := -1
+	if r.Count > 0 {
+		remaining = r.Count - 1
+	}
+
+	var occurrences []time.Time
+
+	for period := 0; period < maxPeriods && len(occurrences) < n; period++ {
+		for _, t := range r.expand(dtstart, period) {
+			switch {
+			case !t.After(dtstart):
+				continue
+			case !until.IsZero() && t.After(until), remaining == 0, len(occurrences) == n:
+				return occurrences
+			}
+
+			occurrences = append(occurrences, t)
+			if remaining > 0 {
+				remaining--
+			}
+		}
+	}
+	return occurrences
+}
+
+// expand returns the candidate occurrences in the given period after the one of
+// dtstart, in order.
+func (r *Rule) expand(dtstart time.Time, period int) []time.Time {
+
+	y, m, d := dtstart.Date()
+	step := period * r.Interval
+
+	var days []time.Time
+
+	switch r.Freq {
+	case Daily:
+		day := date(y, m, d+step)
+		if r.matchesMonth(day.Month()) && r.matchesMonthDay(day) && r.matchesWeekday(day.Weekday()) {
+			days = append(days, day)
+		}
+
+	case Weekly:
+		// weeks start on Monday
+		monday := d - (int(dtstart.Weekday())+6)%7 + 7*step
+		byDay := r.ByDay
+		if len(byDay) == 0 {
+			byDay = []WeekdayNum{{Day: dtstart.Weekday()}}
+		}
+		for _, wd := range byDay {
+			day := date(y, m, monday+(int(wd.Day)+6)%7)
+			if r.matchesMonth(day.Month()) {
+				days = append(days, day)
+			}
+		}
+
+	case Monthly:
+		first := date(y, m+time.Month(step), 1)
+		if r.matchesMonth(first.Month()) {
+			days = r.monthDays(first.Year(), first.Month(), d)
+		}
+
+	case Yearly:
+		year := y + step
+		if len(r.ByMonth) == 0 && len(r.ByMonthDay) == 0 && len(r.ByDay) > 0 {
+			days = r.yearWeekdays(year)
+			break
+		}
+
+		months := r.ByMonth
+		if len(months) == 0 {
+			months = []time.Month{m}
+		}
+		for _, month := range months {
+			days = append(days, r.monthDays(year, month, d)...)
+		}
+	}
+
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+
+	// put the days at the time of day of dtstart, in its location
+	h, min, s := dtstart.Clock()
+	occurrences := make([]time.Time, 0, len(days))
+	for i, day := range days {
+		if i > 0 && day.Equal(days[i-1]) {
+			continue
+		}
+		occurrences = append(occurrences, time.Date(day.Year(), day.Month(), day.Day(), h, min, s, 0, dtstart.Location()))
+	}
+	return occurrences
+}
+
+// monthDays returns the days of the month selected by BYMONTHDAY and BYDAY, or the
+// day of dtstart (given as d) when there are neither. Months without that day are
+// skipped, as RFC 5545 requires.
+func (r *Rule) monthDays(year int, month time.Month, d int) []time.Time {
+
+	last := date(year, month+1, 0).Day()
+
+	if len(r.ByMonthDay) == 0 && len(r.ByDay) == 0 {
+		if d > last {
+			return nil
+		}
+		return []time.Time{date(year, month, d)}
+	}
+
+	var days []time.Time
+
+	for day := 1; day <= last; day++ {
+		t := date(year, month, day)
+
+		if len(r.ByMonthDay) > 0 && !r.matchesMonthDay(t) {
+			continue
+		}
+		if len(r.ByDay) > 0 && !matchesNthWeekday(r.ByDay, t, day, last) {
+			continue
+		}
+		days = append(days, t)
+	}
+	return days
+}
+
+// yearWeekdays returns the days of the year selected by BYDAY, where a number picks
+// one of those weekdays in the whole year.
+func (r *Rule) yearWeekdays(year int) []time.Time {
+
+	last := date(year, 12, 31).YearDay()
+
+	var days []time.Time
+	for day := 1; day <= last; day++ {
+		t := date(year, 1, day)
+		if matchesNthWeekday(r.ByDay, t, day, last) {
+			days = append(days, t)
+		}
+	}
+	return days
+}
+
+// matchesNthWeekday reports whether t, which is day number i of a month or year of
+// last days, matches any of the BYDAY values.
+func matchesNthWeekday(byDay []WeekdayNum, t time.Time, i, last int) bool {
+	for _, wd := range byDay {
+		if wd.Day != t.Weekday() {
+			continue
+		}
+		switch {
+		case wd.N == 0:
+			return true
+		case wd.N > 0 && (i-1)/7+1 == wd.N:
+			return true
+		case wd.N < 0 && (last-i)/7+1 == -wd.N:
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchesMonth(month time.Month) bool {
+	if len(r.ByMonth) == 0 {
+		return true
+	}
+	for _, m := range r.ByMonth {
+		if m == month {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchesMonthDay(t time.Time) bool {
+	if len(r.ByMonthDay) == 0 {
+		return true
+	}
+	last := date(t.Year(), t.Month()+1, 0).Day()
+	for _, d := range r.ByMonthDay {
+		if d == t.Day() || last+1+d == t.Day() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchesWeekday(day time.Weekday) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, wd := range r.ByDay {
+		if wd.Day == day {
+			return true
+		}
+	}
+	return false
+}
+
+// date returns midnight UTC of the given day, normalizing out of range values the
+// way time.Date does. The calendar arithmetic happens in UTC so that it isn't thrown
+// off by daylight saving changes.
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,479 @@
+package rrule
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParse(t *testing.T) {
+
+	tests := []struct {
+		input string
+		want  *Rule
+	}{
+		{"FREQ=DAILY", &Rule{Freq: Daily, Interval: 1}},
+		{"RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR;COUNT=10", &Rule{
+			Freq:     Weekly,
+			Interval: 2,
+			Count:    10,
+			ByDay:    []WeekdayNum{{Day: time.Monday}, {Day: time.Friday}},
+		}},
+		{"freq=monthly;byday=+2tu,-1fr;wkst=mo", &Rule{
+			Freq:     Monthly,
+			Interval: 1,
+			ByDay:    []WeekdayNum{{N: 2, Day: time.Tuesday}, {N: -1, Day: time.Friday}},
+		}},
+		{"FREQ=YEARLY;BYMONTH=2,11;BYMONTHDAY=1,-1", &Rule{
+			Freq:       Yearly,
+			Interval:   1,
+			ByMonth:    []time.Month{time.February, time.November},
+			ByMonthDay: []int{1, -1},
+		}},
+		{"FREQ=DAILY;UNTIL=20301231", &Rule{
+			Freq:      Daily,
+			Interval:  1,
+			until:     time.Date(2030, 12, 31, 23, 59, 59, 0, time.UTC),
+			untilForm: untilDate,
+		}},
+		{"FREQ=DAILY;UNTIL=20301231T090000", &Rule{
+			Freq:      Daily,
+			Interval:  1,
+			until:     time.Date(2030, 12, 31, 9, 0, 0, 0, time.UTC),
+			untilForm: untilLocal,
+		}},
+		{"FREQ=DAILY;UNTIL=20301231T090000Z", &Rule{
+			Freq:      Daily,
+			Interval:  1,
+			until:     time.Date(2030, 12, 31, 9, 0, 0, 0, time.UTC),
+			untilForm: untilUTC,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := Parse(tt.input)
+			if err != nil {
+				t.Fatalf("Parse(%q) returned error: %v", tt.input, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %#v, want %#v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", "empty rule"},
+		{"RRULE:", "empty rule"},
+		{"INTERVAL=2", "FREQ is required"},
+		{"FREQ", `malformed part "FREQ"`},
+		{"FREQ=", `malformed part "FREQ="`},
+		{"FREQ=DAILY;", `malformed part ""`},
+		{"FREQ=HOURLY", "FREQ must be DAILY, WEEKLY, MONTHLY or YEARLY"},
+		{"FREQ=DAILY;FREQ=WEEKLY", "FREQ given more than once"},
+		{"FREQ=DAILY;INTERVAL=0", `"0" must be a number from 1 to 1000`},
+		{"FREQ=DAILY;INTERVAL=x", `"x" must be a number from 1 to 1000`},
+		{"FREQ=DAILY;COUNT=0", `"0" must be a number from 1 to 10000`},
+		{"FREQ=DAILY;COUNT=2;UNTIL=20301231", "COUNT and UNTIL can't both be given"},
+		{"FREQ=DAILY;UNTIL=2030-12-31", "UNTIL must be a date or date-time"},
+		{"FREQ=DAILY;UNTIL=20301331", "UNTIL must be a date or date-time"},
+		{"FREQ=MONTHLY;BYMONTHDAY=0", "0 is not a valid value"},
+		{"FREQ=MONTHLY;BYMONTHDAY=32", `"32" must be a number from -31 to 31`},
+		{"FREQ=YEARLY;BYMONTH=13", `"13" must be a number from 1 to 12`},
+		{"FREQ=WEEKLY;BYDAY=XX", `invalid BYDAY value "XX"`},
+		{"FREQ=WEEKLY;BYDAY=M", `invalid BYDAY value "M"`},
+		{"FREQ=MONTHLY;BYDAY=0MO", `invalid BYDAY value "0MO"`},
+		{"FREQ=MONTHLY;BYDAY=54MO", `invalid BYDAY value "54MO"`},
+		{"FREQ=WEEKLY;BYDAY=1MO", "numbered BYDAY values need a MONTHLY or YEARLY rule"},
+		{"FREQ=DAILY;BYDAY=-1FR", "numbered BYDAY values need a MONTHLY or YEARLY rule"},
+		{"FREQ=WEEKLY;BYMONTHDAY=1", "BYMONTHDAY can't be used with a WEEKLY rule"},
+		{"FREQ=WEEKLY;WKST=SU", "only WKST=MO is supported"},
+		{"FREQ=MONTHLY;BYSETPOS=-1", "BYSETPOS is not supported"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := Parse(tt.input)
+			if !errors.Is(err, ErrInvalidRule) {
+				t.Fatalf("Parse(%q) returned error %v, want ErrInvalidRule", tt.input, err)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse(%q) returned error %q, want it to contain %q", tt.input, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"FREQ=DAILY", "FREQ=DAILY"},
+		{"FREQ=DAILY;INTERVAL=1", "FREQ=DAILY"},
+		{"RRULE:freq=weekly;byday=mo,fr;interval=2", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR"},
+		{"FREQ=MONTHLY;BYDAY=+2TU,-1FR;COUNT=5;WKST=MO", "FREQ=MONTHLY;COUNT=5;BYDAY=2TU,-1FR"},
+		{"BYDAY=-1SU;BYMONTH=3,10;FREQ=YEARLY;UNTIL=20301231", "FREQ=YEARLY;UNTIL=20301231;BYMONTH=3,10;BYDAY=-1SU"},
+		{"FREQ=MONTHLY;BYMONTHDAY=1,-1;UNTIL=20301231T090000", "FREQ=MONTHLY;UNTIL=20301231T090000;BYMONTHDAY=1,-1"},
+		{"FREQ=YEARLY;UNTIL=20301231T090000Z", "FREQ=YEARLY;UNTIL=20301231T090000Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			r, err := Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s := r.String()
+			if s != tt.want {
+				t.Errorf("String() = %q, want %q", s, tt.want)
+			}
+
+			// what String writes reads back as the same rule
+			again, err := Parse(s)
+			if err != nil {
+				t.Fatalf("Parse(%q) returned error: %v", s, err)
+			}
+			if !reflect.DeepEqual(again, r) {
+				t.Errorf("Parse(String()) = %#v, want %#v", again, r)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// at returns 09:00 on the given day in New York
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 9, 0, 0, 0, newYork)
+	}
+
+	tests := []struct {
+		name    string
+		rule    string
+		dtstart time.Time
+		n       int
+		want    []time.Time
+	}{
+		{
+			name:    "daily",
+			rule:    "FREQ=DAILY",
+			dtstart: at(2024, 1, 30),
+			n:       3,
+			want:    []time.Time{at(2024, 1, 31), at(2024, 2, 1), at(2024, 2, 2)},
+		},
+		{
+			name:    "every other day",
+			rule:    "FREQ=DAILY;INTERVAL=2",
+			dtstart: at(2024, 2, 27),
+			n:       3,
+			want:    []time.Time{at(2024, 2, 29), at(2024, 3, 2), at(2024, 3, 4)},
+		},
+		{
+			name:    "count includes dtstart",
+			rule:    "FREQ=DAILY;COUNT=3",
+			dtstart: at(2024, 1, 1),
+			n:       10,
+			want:    []time.Time{at(2024, 1, 2), at(2024, 1, 3)},
+		},
+		{
+			name:    "count of one has nothing after dtstart",
+			rule:    "FREQ=DAILY;COUNT=1",
+			dtstart: at(2024, 1, 1),
+			n:       10,
+			want:    nil,
+		},
+		{
+			name:    "count includes dtstart even when it doesn't match",
+			rule:    "FREQ=WEEKLY;BYDAY=FR;COUNT=3",
+			dtstart: at(2024, 1, 1),
+			n:       10,
+			want:    []time.Time{at(2024, 1, 5), at(2024, 1, 12)},
+		},
+		{
+			name:    "until is inclusive",
+			rule:    "FREQ=DAILY;UNTIL=20240104T090000",
+			dtstart: at(2024, 1, 1),
+			n:       10,
+			want:    []time.Time{at(2024, 1, 2), at(2024, 1, 3), at(2024, 1, 4)},
+		},
+		{
+			name:    "until as a date covers the whole day",
+			rule:    "FREQ=DAILY;UNTIL=20240103",
+			dtstart: at(2024, 1, 1),
+			n:       10,
+			want:    []time.Time{at(2024, 1, 2), at(2024, 1, 3)},
+		},
+		{
+			name:    "until in UTC",
+			rule:    "FREQ=DAILY;UNTIL=20240103T140000Z",
+			dtstart: at(2024, 1, 1),
+			n:       10,
+			want:    []time.Time{at(2024, 1, 2), at(2024, 1, 3)},
+		},
+		{
+			name:    "until in UTC just before the occurrence",
+			rule:    "FREQ=DAILY;UNTIL=20240103T135959Z",
+			dtstart: at(2024, 1, 1),
+			n:       10,
+			want:    []time.Time{at(2024, 1, 2)},
+		},
+		{
+			name:    "daily in some months",
+			rule:    "FREQ=DAILY;BYMONTH=1",
+			dtstart: at(2023, 12, 30),
+			n:       3,
+			want:    []time.Time{at(2024, 1, 1), at(2024, 1, 2), at(2024, 1, 3)},
+		},
+		{
+			name:    "weekly on the day of dtstart",
+			rule:    "FREQ=WEEKLY",
+			dtstart: at(2024, 2, 22),
+			n:       2,
+			want:    []time.Time{at(2024, 2, 29), at(2024, 3, 7)},
+		},
+		{
+			name:    "weekly on several days",
+			rule:    "FREQ=WEEKLY;BYDAY=MO,WE,FR",
+			dtstart: at(2024, 1, 1),
+			n:       4,
+			want:    []time.Time{at(2024, 1, 3), at(2024, 1, 5), at(2024, 1, 8), at(2024, 1, 10)},
+		},
+		{
+			name:    "every other week, weeks starting on Monday",
+			rule:    "FREQ=WEEKLY;INTERVAL=2;BYDAY=TU,SU",
+			dtstart: at(2024, 1, 2),
+			n:       4,
+			want:    []time.Time{at(2024, 1, 7), at(2024, 1, 16), at(2024, 1, 21), at(2024, 1, 30)},
+		},
+		{
+			name:    "monthly on the 31st skips shorter months",
+			rule:    "FREQ=MONTHLY",
+			dtstart: at(2024, 1, 31),
+			n:       4,
+			want:    []time.Time{at(2024, 3, 31), at(2024, 5, 31), at(2024, 7, 31), at(2024, 8, 31)},
+		},
+		{
+			name:    "BYMONTHDAY=31 skips shorter months",
+			rule:    "FREQ=MONTHLY;BYMONTHDAY=31",
+			dtstart: at(2024, 1, 15),
+			n:       3,
+			want:    []time.Time{at(2024, 1, 31), at(2024, 3, 31), at(2024, 5, 31)},
+		},
+		{
+			name:    "last day of the month",
+			rule:    "FREQ=MONTHLY;BYMONTHDAY=-1",
+			dtstart: at(2024, 1, 31),
+			n:       3,
+			want:    []time.Time{at(2024, 2, 29), at(2024, 3, 31), at(2024, 4, 30)},
+		},
+		{
+			// RFC 5545, monthly on the first and last day of the month for 10 occurrences
+			name:    "first and last day of the month",
+			rule:    "FREQ=MONTHLY;COUNT=10;BYMONTHDAY=1,-1",
+			dtstart: at(1997, 9, 30),
+			n:       20,
+			want: []time.Time{
+				at(1997, 10, 1), at(1997, 10, 31), at(1997, 11, 1), at(1997, 11, 30),
+				at(1997, 12, 1), at(1997, 12, 31), at(1998, 1, 1), at(1998, 1, 31),
+				at(1998, 2, 1),
+			},
+		},
+		{
+			name:    "second Tuesday",
+			rule:    "FREQ=MONTHLY;BYDAY=2TU",
+			dtstart: at(2024, 1, 9),
+			n:       3,
+			want:    []time.Time{at(2024, 2, 13), at(2024, 3, 12), at(2024, 4, 9)},
+		},
+		{
+			name:    "last Friday",
+			rule:    "FREQ=MONTHLY;BYDAY=-1FR",
+			dtstart: at(2024, 1, 26),
+			n:       3,
+			want:    []time.Time{at(2024, 2, 23), at(2024, 3, 29), at(2024, 4, 26)},
+		},
+		{
+			name:    "fifth Monday skips months without one",
+			rule:    "FREQ=MONTHLY;BYDAY=5MO",
+			dtstart: at(2024, 1, 29),
+			n:       3,
+			want:    []time.Time{at(2024, 4, 29), at(2024, 7, 29), at(2024, 9, 30)},
+		},
+		{
+			// RFC 5545, every Friday the 13th
+			name:    "Friday the 13th",
+			rule:    "FREQ=MONTHLY;BYDAY=FR;BYMONTHDAY=13",
+			dtstart: at(1997, 9, 2),
+			n:       3,
+			want:    []time.Time{at(1998, 2, 13), at(1998, 3, 13), at(1998, 11, 13)},
+		},
+		{
+			name:    "yearly on the 29th of February",
+			rule:    "FREQ=YEARLY",
+			dtstart: at(2024, 2, 29),
+			n:       2,
+			want:    []time.Time{at(2028, 2, 29), at(2032, 2, 29)},
+		},
+		{
+			name:    "fourth Thursday of November",
+			rule:    "FREQ=YEARLY;BYMONTH=11;BYDAY=4TH",
+			dtstart: at(2024, 11, 28),
+			n:       2,
+			want:    []time.Time{at(2025, 11, 27), at(2026, 11, 26)},
+		},
+		{
+			// RFC 5545, Monday of week number 20 written as the 20th Monday of the year
+			name:    "20th Monday of the year",
+			rule:    "FREQ=YEARLY;BYDAY=20MO",
+			dtstart: at(1997, 5, 19),
+			n:       2,
+			want:    []time.Time{at(1998, 5, 18), at(1999, 5, 17)},
+		},
+		{
+			name:    "last Sunday of the year",
+			rule:    "FREQ=YEARLY;BYDAY=-1SU",
+			dtstart: at(2023, 12, 31),
+			n:       2,
+			want:    []time.Time{at(2024, 12, 29), at(2025, 12, 28)},
+		},
+		{
+			name:    "never matches",
+			rule:    "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30",
+			dtstart: at(2024, 1, 1),
+			n:       1,
+			want:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := r.Next(tt.dtstart, tt.n)
+			if !equalTimes(got, tt.want) {
+				t.Errorf("Next(%s, %d)\n got %v\nwant %v", tt.dtstart, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestNextDaylightSaving checks that occurrences keep their wall clock time when the
+// offset from UTC changes in between.
+func TestNextDaylightSaving(t *testing.T) {
+
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		rule    string
+		dtstart time.Time
+		wantUTC []time.Time
+	}{
+		{
+			name:    "into summer time",
+			rule:    "FREQ=DAILY;COUNT=3",
+			dtstart: time.Date(2024, 3, 9, 9, 0, 0, 0, newYork),
+			wantUTC: []time.Time{
+				time.Date(2024, 3, 10, 13, 0, 0, 0, time.UTC),
+				time.Date(2024, 3, 11, 13, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:    "out of summer time",
+			rule:    "FREQ=WEEKLY;COUNT=3",
+			dtstart: time.Date(2024, 10, 27, 9, 0, 0, 0, newYork),
+			wantUTC: []time.Time{
+				time.Date(2024, 11, 3, 14, 0, 0, 0, time.UTC),
+				time.Date(2024, 11, 10, 14, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:    "late evening stays on its day",
+			rule:    "FREQ=MONTHLY;COUNT=3",
+			dtstart: time.Date(2024, 2, 10, 23, 30, 0, 0, newYork),
+			wantUTC: []time.Time{
+				time.Date(2024, 3, 11, 3, 30, 0, 0, time.UTC),
+				time.Date(2024, 4, 11, 3, 30, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := r.Next(tt.dtstart, 10)
+			if !equalTimes(got, tt.wantUTC) {
+				t.Fatalf("Next(%s)\n got %v\nwant %v", tt.dtstart, got, tt.wantUTC)
+			}
+
+			h, m, _ := tt.dtstart.Clock()
+			for _, occurrence := range got {
+				if oh, om, _ := occurrence.Clock(); oh != h || om != m || occurrence.Location() != newYork {
+					t.Errorf("occurrence %s is not at %02d:%02d in New York", occurrence, h, m)
+				}
+			}
+		})
+	}
+}
+
+// TestNextUntilLocal checks that a floating UNTIL applies in the location of dtstart.
+func TestNextUntilLocal(t *testing.T) {
+
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Parse("FREQ=DAILY;UNTIL=20240103T080000")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := r.Next(time.Date(2024, 1, 1, 8, 0, 0, 0, tokyo), 10)
+	want := []time.Time{
+		time.Date(2024, 1, 2, 8, 0, 0, 0, tokyo),
+		time.Date(2024, 1, 3, 8, 0, 0, 0, tokyo),
+	}
+	if !equalTimes(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func equalTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
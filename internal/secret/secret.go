@@ -0,0 +1,68 @@
+// Package secret encrypts small values, such as TOTP secrets, before they are stored
+// in the database.
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// ErrDecrypt is returned when a ciphertext can't be decrypted, because it was either
+// tampered with or encrypted with another key.
+var ErrDecrypt = errors.New("secret: unable to decrypt value")
+
+// Box encrypts and authenticates values using AES-256-GCM.
+type Box struct {
+	aead cipher.AEAD
+}
+
+// New returns a Box using the given 32 byte key.
+func New(key []byte) (*Box, error) {
+
+	if len(key) != 32 {
+		return nil, fmt.Errorf("secret: key must be 32 bytes long, got %d", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Box{aead: aead}, nil
+}
+
+// Seal encrypts plaintext. The random nonce is prepended to the returned ciphertext.
+func (b *Box) Seal(plaintext []byte) ([]byte, error) {
+
+	nonce := make([]byte, b.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open decrypts a ciphertext returned by Seal.
+func (b *Box) Open(ciphertext []byte) ([]byte, error) {
+
+	size := b.aead.NonceSize()
+	if len(ciphertext) < size {
+		return nil, ErrDecrypt
+	}
+
+	plaintext, err := b.aead.Open(nil, ciphertext[:size], ciphertext[size:], nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	return plaintext, nil
+}
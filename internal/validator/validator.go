@@ -11,6 +11,9 @@ import (
 // note further down the page.
 var (
 	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
+	// ColorRX matches hex colors such as #1e90ff.
+	ColorRX = regexp.MustCompile("^#[0-9a-fA-F]{6}$")
 )
 
 // Define a new Validator type which contains a map of validation errors.
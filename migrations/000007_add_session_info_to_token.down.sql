@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS token_user_id_idx;
+
+ALTER TABLE token
+DROP COLUMN IF EXISTS ip_address,
+DROP COLUMN IF EXISTS user_agent,
+DROP COLUMN IF EXISTS last_used_at,
+DROP COLUMN IF EXISTS creation_time,
+DROP COLUMN IF EXISTS id;
@@ -0,0 +1,8 @@
+ALTER TABLE token
+ADD COLUMN IF NOT EXISTS id bigserial UNIQUE,
+ADD COLUMN IF NOT EXISTS creation_time timestamp(0) with time zone NOT NULL DEFAULT (now()),
+ADD COLUMN IF NOT EXISTS last_used_at timestamp(0) with time zone,
+ADD COLUMN IF NOT EXISTS user_agent text NOT NULL DEFAULT '',
+ADD COLUMN IF NOT EXISTS ip_address text NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS token_user_id_idx ON token(user_id);
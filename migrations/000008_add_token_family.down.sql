@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS token_family_idx;
+
+ALTER TABLE token
+DROP COLUMN IF EXISTS rotated_at,
+DROP COLUMN IF EXISTS family;
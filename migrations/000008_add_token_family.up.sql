@@ -0,0 +1,5 @@
+ALTER TABLE token
+ADD COLUMN IF NOT EXISTS family text,
+ADD COLUMN IF NOT EXISTS rotated_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS token_family_idx ON token(family);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS token_denylist (
+    id bigserial PRIMARY KEY,
+    jti text,
+    user_id bigint REFERENCES users(id) ON DELETE CASCADE,
+    revoked_at timestamp(0) with time zone NOT NULL DEFAULT (now()),
+    expiry timestamp(0) with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS token_denylist_expiry_idx ON token_denylist(expiry);
@@ -0,0 +1,3 @@
+ALTER TABLE token
+DROP COLUMN IF EXISTS api_scopes,
+DROP COLUMN IF EXISTS name;
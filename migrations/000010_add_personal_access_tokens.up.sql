@@ -0,0 +1,3 @@
+ALTER TABLE token
+ADD COLUMN IF NOT EXISTS name text NOT NULL DEFAULT '',
+ADD COLUMN IF NOT EXISTS api_scopes text[] NOT NULL DEFAULT '{}';
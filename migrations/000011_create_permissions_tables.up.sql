@@ -0,0 +1,52 @@
+CREATE TABLE IF NOT EXISTS permissions (
+    id bigserial PRIMARY KEY,
+    code text NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS users_permissions (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (user_id, permission_id)
+);
+
+-- roles are named bundles of permissions, granting a role to a user copies its
+-- permissions into users_permissions
+CREATE TABLE IF NOT EXISTS roles (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions (
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+INSERT INTO permissions (code)
+VALUES
+    ('todos:read'),
+    ('todos:write'),
+    ('users:admin')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO roles (name)
+VALUES
+    ('member'),
+    ('admin')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles CROSS JOIN permissions
+WHERE roles.name = 'admin'
+    OR (roles.name = 'member' AND permissions.code IN ('todos:read', 'todos:write'))
+ON CONFLICT DO NOTHING;
+
+-- existing users keep the access they had so far
+INSERT INTO users_permissions (user_id, permission_id)
+SELECT users.id, roles_permissions.permission_id
+FROM users
+    CROSS JOIN roles_permissions
+    INNER JOIN roles ON roles.id = roles_permissions.role_id
+WHERE roles.name = 'member'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,4 @@
+ALTER TABLE token
+DROP COLUMN IF EXISTS impersonator_id;
+
+DROP TABLE IF EXISTS audit_log;
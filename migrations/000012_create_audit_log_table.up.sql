@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+    id bigserial PRIMARY KEY,
+    actor_id bigint REFERENCES users(id) ON DELETE SET NULL,
+    action text NOT NULL,
+    target_user_id bigint,
+    details jsonb NOT NULL DEFAULT '{}',
+    ip_address text NOT NULL DEFAULT '',
+    creation_time timestamp(0) with time zone NOT NULL DEFAULT (now())
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_target_user_id_idx ON audit_log(target_user_id);
+CREATE INDEX IF NOT EXISTS audit_log_creation_time_idx ON audit_log(creation_time);
+
+ALTER TABLE token
+ADD COLUMN IF NOT EXISTS impersonator_id bigint REFERENCES users(id) ON DELETE CASCADE;
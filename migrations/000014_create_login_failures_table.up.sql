@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS login_failures (
+    key text PRIMARY KEY,
+    failures integer NOT NULL DEFAULT 0,
+    last_failure timestamp(0) with time zone NOT NULL DEFAULT (now()),
+    locked_until timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS login_failures_last_failure_idx ON login_failures(last_failure);
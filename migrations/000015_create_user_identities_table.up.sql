@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS user_identities (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider text NOT NULL,
+    subject text NOT NULL,
+    email citext NOT NULL DEFAULT '',
+    creation_time timestamp(0) with time zone NOT NULL DEFAULT (now()),
+    last_login_at timestamp(0) with time zone,
+    UNIQUE (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS user_identities_user_id_idx ON user_identities(user_id);
+
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+    hash bytea PRIMARY KEY,
+    provider text NOT NULL,
+    nonce text NOT NULL,
+    code_verifier text NOT NULL,
+    expiry timestamp(0) with time zone NOT NULL
+);
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS data_exports;
+DROP TABLE IF EXISTS account_deletions;
+
+ALTER TABLE todo
+DROP CONSTRAINT IF EXISTS todo_user_id_fkey;
+
+ALTER TABLE todo
+ADD CONSTRAINT todo_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE RESTRICT;
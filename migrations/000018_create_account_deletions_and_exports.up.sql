@@ -0,0 +1,28 @@
+ALTER TABLE todo
+DROP CONSTRAINT IF EXISTS todo_user_id_fkey;
+
+ALTER TABLE todo
+ADD CONSTRAINT todo_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+CREATE TABLE IF NOT EXISTS account_deletions (
+    user_id bigint PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    scheduled_for timestamp(0) with time zone NOT NULL,
+    creation_time timestamp(0) with time zone NOT NULL DEFAULT (now())
+);
+
+CREATE INDEX IF NOT EXISTS account_deletions_scheduled_for_idx ON account_deletions(scheduled_for);
+
+CREATE TABLE IF NOT EXISTS data_exports (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status text NOT NULL DEFAULT 'pending',
+    archive bytea,
+    error text NOT NULL DEFAULT '',
+    creation_time timestamp(0) with time zone NOT NULL DEFAULT (now()),
+    started_at timestamp(0) with time zone,
+    completed_at timestamp(0) with time zone,
+    expiry timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS data_exports_user_id_idx ON data_exports(user_id);
+CREATE INDEX IF NOT EXISTS data_exports_status_idx ON data_exports(status);
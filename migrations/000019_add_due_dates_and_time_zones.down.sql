@@ -0,0 +1,6 @@
+ALTER TABLE users DROP COLUMN IF EXISTS time_zone;
+
+DROP INDEX IF EXISTS todo_user_id_due_at_idx;
+
+ALTER TABLE todo DROP COLUMN IF EXISTS all_day;
+ALTER TABLE todo DROP COLUMN IF EXISTS due_at;
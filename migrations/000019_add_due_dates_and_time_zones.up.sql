@@ -0,0 +1,6 @@
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS due_at timestamp(0) with time zone;
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS all_day boolean NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS todo_user_id_due_at_idx ON todo (user_id, due_at);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS time_zone text NOT NULL DEFAULT 'UTC';
@@ -0,0 +1,3 @@
+ALTER TABLE todo DROP CONSTRAINT IF EXISTS todo_priority_check;
+
+ALTER TABLE todo DROP COLUMN IF EXISTS priority;
@@ -0,0 +1,4 @@
+-- priorities are stored as their rank (0 none .. 4 urgent), so they sort by urgency
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS priority smallint NOT NULL DEFAULT 0;
+
+ALTER TABLE todo ADD CONSTRAINT todo_priority_check CHECK (priority BETWEEN 0 AND 4);
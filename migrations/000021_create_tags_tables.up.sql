@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS tags (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name citext NOT NULL,
+    color text NOT NULL DEFAULT '#808080',
+    creation_time timestamp(0) with time zone NOT NULL DEFAULT (now()),
+    version integer NOT NULL DEFAULT 1,
+    UNIQUE (user_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS todo_tags (
+    todo_id bigint NOT NULL REFERENCES todo(id) ON DELETE CASCADE,
+    tag_id bigint NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (todo_id, tag_id)
+);
+
+CREATE INDEX IF NOT EXISTS todo_tags_tag_id_idx ON todo_tags(tag_id);
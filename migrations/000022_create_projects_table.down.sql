@@ -0,0 +1,3 @@
+ALTER TABLE todo DROP COLUMN IF EXISTS project_id;
+
+DROP TABLE IF EXISTS projects;
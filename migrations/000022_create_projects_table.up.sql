@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS projects (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name text NOT NULL,
+    color text NOT NULL DEFAULT '#808080',
+    archived boolean NOT NULL DEFAULT false,
+    position integer NOT NULL DEFAULT 0,
+    creation_time timestamp(0) with time zone NOT NULL DEFAULT (now()),
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS projects_user_id_position_idx ON projects(user_id, position);
+
+-- todos without a project are in the inbox
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS project_id bigint REFERENCES projects(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS todo_project_id_idx ON todo(project_id);
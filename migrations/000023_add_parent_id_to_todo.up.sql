@@ -0,0 +1,4 @@
+-- subtasks are deleted along with their parent
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS parent_id bigint REFERENCES todo(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS todo_parent_id_idx ON todo(parent_id);
@@ -0,0 +1,2 @@
+ALTER TABLE todo DROP COLUMN IF EXISTS rrule_time_zone;
+ALTER TABLE todo DROP COLUMN IF EXISTS rrule;
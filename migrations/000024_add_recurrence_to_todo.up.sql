@@ -0,0 +1,3 @@
+-- a recurring todo carries an RFC 5545 RRULE, evaluated in the time zone next to it
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS rrule text;
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS rrule_time_zone text;
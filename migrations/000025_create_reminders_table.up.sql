@@ -0,0 +1,22 @@
+-- a reminder fires either at remind_at, or offset_minutes from the due date of its
+-- todo (negative is before it), so that it follows the todo when it's rescheduled
+CREATE TABLE IF NOT EXISTS reminders (
+    id bigserial PRIMARY KEY,
+    todo_id bigint NOT NULL REFERENCES todo(id) ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    remind_at timestamp(0) with time zone,
+    offset_minutes integer,
+    channel text NOT NULL DEFAULT 'email',
+    webhook_url text NOT NULL DEFAULT '',
+    status text NOT NULL DEFAULT 'pending',
+    attempts integer NOT NULL DEFAULT 0,
+    retry_at timestamp(0) with time zone,
+    claimed_at timestamp(0) with time zone,
+    sent_at timestamp(0) with time zone,
+    error text NOT NULL DEFAULT '',
+    creation_time timestamp(0) with time zone NOT NULL DEFAULT (now()),
+    CONSTRAINT reminders_when_check CHECK ((remind_at IS NULL) <> (offset_minutes IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS reminders_todo_id_idx ON reminders(todo_id);
+CREATE INDEX IF NOT EXISTS reminders_status_idx ON reminders(status);
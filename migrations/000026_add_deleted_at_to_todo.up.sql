@@ -0,0 +1,4 @@
+-- deleted todos stay in the trash until they are restored or purged
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS todo_deleted_at_idx ON todo(deleted_at) WHERE deleted_at IS NOT NULL;
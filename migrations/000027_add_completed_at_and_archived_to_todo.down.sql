@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS todo_completed_at_idx;
+ALTER TABLE todo DROP COLUMN IF EXISTS archived;
+ALTER TABLE todo DROP COLUMN IF EXISTS completed_at;
@@ -0,0 +1,8 @@
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS completed_at timestamp(0) with time zone;
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS archived boolean NOT NULL DEFAULT false;
+
+-- we don't know when the todos completed so far were finished, so count them as
+-- finished now rather than archiving them all straight away
+UPDATE todo SET completed_at = now() WHERE completed AND completed_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS todo_completed_at_idx ON todo(completed_at) WHERE NOT archived;
@@ -0,0 +1,3 @@
+-- revoked_at is compared with the sub-second issue time of access tokens, rounding it
+-- to whole seconds denied tokens issued just after a revocation
+ALTER TABLE token_denylist ALTER COLUMN revoked_at TYPE timestamp with time zone;
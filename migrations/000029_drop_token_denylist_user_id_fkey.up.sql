@@ -0,0 +1,4 @@
+-- a denylist entry has to outlive the user it revokes the tokens of: deleting the user
+-- would otherwise delete the entry, and let their access tokens work until they expire
+ALTER TABLE token_denylist
+DROP CONSTRAINT IF EXISTS token_denylist_user_id_fkey;